@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"lab8-go/service"
+)
+
+// addAnalytics serves the analytics dashboard and the JSON API behind it.
+// Both read the hourly statistics the visit flusher writes.
+func addAnalytics() {
+	http.HandleFunc("GET /analytics", analyticsPageHandler)
+	handleJSON("GET /api/v1/analytics", analyticsAPIHandler)
+}
+
+// maxAnalyticsDays bounds the range of one report.
+const maxAnalyticsDays = 366
+
+// analyticsQuery is a report request: the UTC days from From to To, both
+// included, optionally for one route, bucketed by hour or by day.
+type analyticsQuery struct {
+	From     time.Time
+	To       time.Time
+	Route    string
+	Interval string
+}
+
+var analyticsParams = []apiParam{
+	{Name: "from", Type: "string", Description: "First day of the report (YYYY-MM-DD, UTC); defaults to six days before to"},
+	{Name: "to", Type: "string", Description: "Last day of the report (YYYY-MM-DD, UTC); defaults to today"},
+	{Name: "route", Type: "string", Description: "Only this route pattern, such as /api/v1/days/{id}"},
+	{Name: "interval", Type: "string", Description: "hour or day; defaults to hour for up to two days and day otherwise"},
+}
+
+// parseAnalyticsQuery reads the report parameters, filling in the
+// defaults described in analyticsParams.
+func parseAnalyticsQuery(q url.Values, now time.Time) (analyticsQuery, error) {
+	aq := analyticsQuery{Route: q.Get("route"), Interval: q.Get("interval")}
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	var err error
+	aq.To = today
+	if v := q.Get("to"); v != "" {
+		if aq.To, err = time.Parse(service.DateLayout, v); err != nil {
+			return aq, &service.ParamError{Param: "to", Message: "must be a date in YYYY-MM-DD format"}
+		}
+	}
+	aq.From = aq.To.AddDate(0, 0, -6)
+	if v := q.Get("from"); v != "" {
+		if aq.From, err = time.Parse(service.DateLayout, v); err != nil {
+			return aq, &service.ParamError{Param: "from", Message: "must be a date in YYYY-MM-DD format"}
+		}
+	}
+	days := int(aq.To.Sub(aq.From).Hours()/24) + 1
+	switch {
+	case days < 1:
+		return aq, &service.ParamError{Param: "from", Message: "must not be after to"}
+	case days > maxAnalyticsDays:
+		return aq, &service.ParamError{Param: "from", Message: fmt.Sprintf("must be at most %d days before to", maxAnalyticsDays-1)}
+	}
+
+	switch aq.Interval {
+	case "":
+		aq.Interval = "day"
+		if days <= 2 {
+			aq.Interval = "hour"
+		}
+	case "hour", "day":
+	default:
+		return aq, &service.ParamError{Param: "interval", Message: "must be hour or day"}
+	}
+	return aq, nil
+}
+
+// step is the length of one bucket of the report.
+func (aq analyticsQuery) step() time.Duration {
+	if aq.Interval == "hour" {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// analyticsReport is the /api/v1/analytics body. Latencies are estimated
+// from histogram buckets, so they are accurate to the bucket they fall in.
+type analyticsReport struct {
+	From     string         `json:"from" format:"date"`
+	To       string         `json:"to" format:"date"`
+	Route    string         `json:"route,omitempty"`
+	Interval string         `json:"interval"`
+	Totals   routeSummary   `json:"totals"`
+	Routes   []routeSummary `json:"routes"`
+	Series   []seriesPoint  `json:"series"`
+}
+
+type routeSummary struct {
+	Route         string  `json:"route,omitempty"`
+	Requests      int64   `json:"requests"`
+	Errors        int64   `json:"errors"`
+	P50MS         float64 `json:"p50_ms"`
+	P95MS         float64 `json:"p95_ms"`
+	UniqueClients int64   `json:"unique_clients"`
+	AllTime       int64   `json:"all_time_requests,omitempty"`
+}
+
+type seriesPoint struct {
+	Time          time.Time `json:"time"`
+	Requests      int64     `json:"requests"`
+	Errors        int64     `json:"errors"`
+	P50MS         float64   `json:"p50_ms"`
+	P95MS         float64   `json:"p95_ms"`
+	UniqueClients int64     `json:"unique_clients"`
+}
+
+// latencyHistogram maps bucket upper bounds in milliseconds to counts.
+type latencyHistogram map[float64]int64
+
+// quantile estimates the q-quantile by interpolating within the bucket it
+// falls in. Anything in the open-ended last bucket is reported at the
+// highest finite bound.
+func (h latencyHistogram) quantile(q float64) float64 {
+	bounds := make([]float64, 0, len(h))
+	var total int64
+	for le, n := range h {
+		bounds = append(bounds, le)
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	sort.Float64s(bounds)
+
+	rank := q * float64(total)
+	var below int64
+	lower := 0.0
+	for _, le := range bounds {
+		n := h[le]
+		if float64(below+n) >= rank {
+			if math.IsInf(le, 1) {
+				return lower
+			}
+			return math.Round((lower+(le-lower)*(rank-float64(below))/float64(n))*10) / 10
+		}
+		below += n
+		lower = le
+	}
+	return lower
+}
+
+// loadAnalytics builds the report for aq from the hourly tables.
+func loadAnalytics(ctx context.Context, aq analyticsQuery) (analyticsReport, error) {
+	report := analyticsReport{
+		From:     aq.From.Format(service.DateLayout),
+		To:       aq.To.Format(service.DateLayout),
+		Route:    aq.Route,
+		Interval: aq.Interval,
+		Routes:   []routeSummary{},
+		Series:   []seriesPoint{},
+	}
+	start, end := aq.From, aq.To.AddDate(0, 0, 1)
+	args := []any{start, end, aq.Route, aq.Interval}
+	const where = "hour >= $1 AND hour < $2 AND ($3 = '' OR route = $3)"
+	const bucket = "date_trunc($4::text, hour AT TIME ZONE 'UTC')"
+
+	routes := map[string]*routeSummary{}
+	route := func(name string) *routeSummary {
+		if routes[name] == nil {
+			routes[name] = &routeSummary{Route: name}
+		}
+		return routes[name]
+	}
+	points := map[time.Time]*seriesPoint{}
+	for t := start; t.Before(end); t = t.Add(aq.step()) {
+		points[t] = &seriesPoint{Time: t}
+	}
+	point := func(t time.Time) *seriesPoint {
+		if p := points[t.UTC()]; p != nil {
+			return p
+		}
+		return &seriesPoint{} // outside the range; never reported
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT route, "+bucket+", sum(requests), sum(errors) FROM route_stats_hourly WHERE "+where+" GROUP BY 1, 2", args...)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var t time.Time
+		var requests, errs int64
+		if err := rows.Scan(&name, &t, &requests, &errs); err != nil {
+			return report, err
+		}
+		for _, s := range []*routeSummary{route(name), &report.Totals} {
+			s.Requests += requests
+			s.Errors += errs
+		}
+		p := point(t)
+		p.Requests += requests
+		p.Errors += errs
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	routeLatency := map[string]latencyHistogram{}
+	pointLatency := map[time.Time]latencyHistogram{}
+	totalLatency := latencyHistogram{}
+	rows, err = db.QueryContext(ctx, "SELECT route, "+bucket+", le_ms, sum(count) FROM route_latency_hourly WHERE "+where+" GROUP BY 1, 2, 3", args...)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var t time.Time
+		var le float64
+		var n int64
+		if err := rows.Scan(&name, &t, &le, &n); err != nil {
+			return report, err
+		}
+		if routeLatency[name] == nil {
+			routeLatency[name] = latencyHistogram{}
+		}
+		if pointLatency[t.UTC()] == nil {
+			pointLatency[t.UTC()] = latencyHistogram{}
+		}
+		routeLatency[name][le] += n
+		pointLatency[t.UTC()][le] += n
+		totalLatency[le] += n
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	// Unique clients do not add up across routes or hours, so they are
+	// counted per route, per bucket and overall in one grouped query.
+	rows, err = db.QueryContext(ctx, `
+        SELECT route, bucket, count(DISTINCT client) FROM (
+            SELECT route, `+bucket+` AS bucket, client FROM route_clients_hourly WHERE `+where+`
+        ) c
+        GROUP BY GROUPING SETS ((route), (bucket), ())`, args...)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name sql.NullString
+		var t sql.NullTime
+		var n int64
+		if err := rows.Scan(&name, &t, &n); err != nil {
+			return report, err
+		}
+		switch {
+		case name.Valid:
+			route(name.String).UniqueClients = n
+		case t.Valid:
+			point(t.Time).UniqueClients = n
+		default:
+			report.Totals.UniqueClients = n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	allTime, err := allTimeVisits(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	report.Totals.P50MS, report.Totals.P95MS = totalLatency.quantile(0.5), totalLatency.quantile(0.95)
+	for name, s := range routes {
+		s.P50MS, s.P95MS = routeLatency[name].quantile(0.5), routeLatency[name].quantile(0.95)
+		s.AllTime = allTime[name]
+		report.Routes = append(report.Routes, *s)
+	}
+	sort.Slice(report.Routes, func(i, j int) bool {
+		if report.Routes[i].Requests != report.Routes[j].Requests {
+			return report.Routes[i].Requests > report.Routes[j].Requests
+		}
+		return report.Routes[i].Route < report.Routes[j].Route
+	})
+	for t := start; t.Before(end); t = t.Add(aq.step()) {
+		p := points[t]
+		p.P50MS, p.P95MS = pointLatency[t].quantile(0.5), pointLatency[t].quantile(0.95)
+		report.Series = append(report.Series, *p)
+	}
+	return report, nil
+}
+
+// allTimeVisits returns the totals kept in endpoint_visits, which outlive
+// the hourly statistics.
+func allTimeVisits(ctx context.Context) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, "SELECT endpoint, visit_count FROM endpoint_visits")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	totals := map[string]int64{}
+	for rows.Next() {
+		var endpoint string
+		var n int64
+		if err := rows.Scan(&endpoint, &n); err != nil {
+			return nil, err
+		}
+		totals[endpoint] = n
+	}
+	return totals, rows.Err()
+}
+
+func analyticsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	aq, err := parseAnalyticsQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		writeErr(w, r, "", err)
+		return
+	}
+	report, err := loadAnalytics(r.Context(), aq)
+	if err != nil {
+		writeErr(w, r, "loading analytics", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+type analyticsPage struct {
+	Report  analyticsReport
+	Routes  []string // every route ever counted, for the filter
+	Charts  []svgChart
+	JSONURL string
+}
+
+func analyticsPageHandler(w http.ResponseWriter, r *http.Request) {
+	aq, err := parseAnalyticsQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		pageError(w, r, "", err)
+		return
+	}
+	report, err := loadAnalytics(r.Context(), aq)
+	if err != nil {
+		pageError(w, r, "loading analytics", err)
+		return
+	}
+	allTime, err := allTimeVisits(r.Context())
+	if err != nil {
+		pageError(w, r, "loading analytics", err)
+		return
+	}
+	routes := make([]string, 0, len(allTime))
+	for route := range allTime {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	q := url.Values{"from": {report.From}, "to": {report.To}, "interval": {report.Interval}}
+	if report.Route != "" {
+		q.Set("route", report.Route)
+	}
+	renderPage(w, r, "analytics", analyticsPage{
+		Report: report,
+		Routes: routes,
+		Charts: []svgChart{
+			lineChart("Requests", "", report.Series, aq.Interval,
+				chartLine{"requests", "requests", func(p seriesPoint) float64 { return float64(p.Requests) }},
+				chartLine{"errors", "errors", func(p seriesPoint) float64 { return float64(p.Errors) }},
+			),
+			lineChart("Latency", " ms", report.Series, aq.Interval,
+				chartLine{"p50", "p50", func(p seriesPoint) float64 { return p.P50MS }},
+				chartLine{"p95", "p95", func(p seriesPoint) float64 { return p.P95MS }},
+			),
+			lineChart("Unique clients", "", report.Series, aq.Interval,
+				chartLine{"clients", "clients", func(p seriesPoint) float64 { return float64(p.UniqueClients) }},
+			),
+		},
+		JSONURL: "/api/v1/analytics?" + q.Encode(),
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyQuantile(t *testing.T) {
+	h := latencyHistogram{10: 50, 100: 40, 1000: 10}
+	if got := h.quantile(0.5); got != 10 {
+		t.Errorf("p50 = %v, want 10", got)
+	}
+	// The 95th request is half way into the 100-1000ms bucket.
+	if got := h.quantile(0.95); got != 550 {
+		t.Errorf("p95 = %v, want 550", got)
+	}
+	if got := (latencyHistogram{5: 1, 10000: 1, math.Inf(1): 8}).quantile(0.95); got != 10000 {
+		t.Errorf("p95 in the open bucket = %v, want 10000", got)
+	}
+	if got := (latencyHistogram{}).quantile(0.5); got != 0 {
+		t.Errorf("p50 of nothing = %v, want 0", got)
+	}
+}
+
+func TestParseAnalyticsQuery(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 4, 0, 0, time.UTC)
+
+	aq, err := parseAnalyticsQuery(url.Values{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := aq.From.Format("2006-01-02") + " " + aq.To.Format("2006-01-02") + " " + aq.Interval; got != "2024-03-04 2024-03-10 day" {
+		t.Errorf("defaults = %s", got)
+	}
+
+	aq, err = parseAnalyticsQuery(url.Values{"from": {"2024-03-09"}, "to": {"2024-03-10"}}, now)
+	if err != nil || aq.Interval != "hour" {
+		t.Errorf("two days: interval %q, err %v; want hour", aq.Interval, err)
+	}
+
+	for _, q := range []url.Values{
+		{"from": {"2024-03-11"}},
+		{"from": {"2022-01-01"}},
+		{"to": {"yesterday"}},
+		{"interval": {"minute"}},
+	} {
+		if _, err := parseAnalyticsQuery(q, now); err == nil {
+			t.Errorf("%v: expected an error", q)
+		}
+	}
+}
+
+func TestAnalyticsPageRendersCharts(t *testing.T) {
+	points := []seriesPoint{
+		{Time: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC), Requests: 3, P50MS: 12},
+		{Time: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), Requests: 7, Errors: 1, P50MS: 20},
+	}
+	body := renderTestPage(t, "analytics", analyticsPage{
+		Report: analyticsReport{From: "2024-03-09", To: "2024-03-10", Interval: "day", Series: points,
+			Routes: []routeSummary{{Route: "/api/v1/days/{id}", Requests: 10}}},
+		Charts: []svgChart{lineChart("Requests", "", points, "day",
+			chartLine{"requests", "requests", func(p seriesPoint) float64 { return float64(p.Requests) }})},
+	})
+	for _, want := range []string{`<polyline class="requests" points="56.0,138.0 708.0,66.0">`, "Mar 10", "/api/v1/days/{id}"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page is missing %q:\n%s", want, body)
+		}
+	}
+}
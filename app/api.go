@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"lab8-go/service"
+)
+
+// addAPIRoutes registers the versioned, resource-oriented JSON API.
+//
+// Collections are nested under their parent (a week's days, a day's
+// workouts and meals, a workout's lifts); individual resources are
+// addressed directly by id. Collections are paged (see service.ListSpec). Creates
+// answer 201 with a Location header and the created resource, deletes
+// answer 204, and every error is an application/problem+json document
+// (see problem).
+func addAPIRoutes() {
+	handleJSON("GET /api/v1/weeks", apiListWeeks)
+	handleJSON("POST /api/v1/weeks", apiCreateWeek)
+	handleJSON("GET /api/v1/weeks/{id}", apiGetWeek)
+	handleJSON("DELETE /api/v1/weeks/{id}", apiDeleteWeek)
+
+	handleJSON("GET /api/v1/weeks/{id}/days", apiListDays)
+	handleJSON("POST /api/v1/weeks/{id}/days", apiCreateDay)
+	handleJSON("GET /api/v1/days/{id}", apiGetDay)
+	handleJSON("DELETE /api/v1/days/{id}", apiDeleteDay)
+
+	handleJSON("GET /api/v1/days/{id}/workouts", apiListWorkouts)
+	handleJSON("POST /api/v1/days/{id}/workouts", apiCreateWorkout)
+	handleJSON("GET /api/v1/workouts/{id}", apiGetWorkout)
+	handleJSON("DELETE /api/v1/workouts/{id}", apiDeleteWorkout)
+
+	handleJSON("GET /api/v1/workouts/{id}/lifts", apiListLifts)
+	handleJSON("POST /api/v1/workouts/{id}/lifts", apiCreateLift)
+	handleJSON("GET /api/v1/lifts/{id}", apiGetLift)
+	handleJSON("DELETE /api/v1/lifts/{id}", apiDeleteLift)
+
+	handleJSON("GET /api/v1/days/{id}/meals", apiListMeals)
+	handleJSON("POST /api/v1/days/{id}/meals", apiCreateMeal)
+	handleJSON("GET /api/v1/meals/{id}", apiGetMeal)
+	handleJSON("DELETE /api/v1/meals/{id}", apiDeleteMeal)
+}
+
+func apiListWeeks(w http.ResponseWriter, r *http.Request) {
+	p, ok := parseList(w, r, service.WeekList)
+	if !ok {
+		return
+	}
+	weeks, err := svc.ListWeeks(r.Context(), p)
+	if err != nil {
+		writeErr(w, r, "fetching weeks", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, weeks)
+}
+
+func apiCreateWeek(w http.ResponseWriter, r *http.Request) {
+	var req service.WeekInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	week, err := svc.CreateWeek(r.Context(), req)
+	if err != nil {
+		writeErr(w, r, "creating week", err)
+		return
+	}
+	writeCreated(w, fmt.Sprintf("/api/v1/weeks/%d", week.ID), week)
+}
+
+func apiGetWeek(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	week, err := svc.GetWeek(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, "fetching week", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, week)
+}
+
+func apiDeleteWeek(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := svc.DeleteWeek(r.Context(), id); err != nil {
+		writeErr(w, r, "deleting week", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiListDays(w http.ResponseWriter, r *http.Request) {
+	weekID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	p, ok := parseList(w, r, service.DayList)
+	if !ok {
+		return
+	}
+	days, err := svc.ListDays(r.Context(), weekID, p)
+	if err != nil {
+		writeErr(w, r, "fetching days", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, days)
+}
+
+func apiCreateDay(w http.ResponseWriter, r *http.Request) {
+	weekID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req service.DayInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	day, err := svc.CreateDay(r.Context(), weekID, req)
+	if err != nil {
+		writeErr(w, r, "creating day", err)
+		return
+	}
+	writeCreated(w, fmt.Sprintf("/api/v1/days/%d", day.ID), day)
+}
+
+func apiGetDay(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	day, err := svc.GetDay(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, "fetching day", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, day)
+}
+
+func apiDeleteDay(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := svc.DeleteDay(r.Context(), id); err != nil {
+		writeErr(w, r, "deleting day", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiListWorkouts(w http.ResponseWriter, r *http.Request) {
+	dayID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	p, ok := parseList(w, r, service.WorkoutList)
+	if !ok {
+		return
+	}
+	workouts, err := svc.ListWorkouts(r.Context(), dayID, p)
+	if err != nil {
+		writeErr(w, r, "fetching workouts", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, workouts)
+}
+
+func apiCreateWorkout(w http.ResponseWriter, r *http.Request) {
+	dayID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req service.WorkoutInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	workout, err := svc.CreateWorkout(r.Context(), dayID, req)
+	if err != nil {
+		writeErr(w, r, "creating workout", err)
+		return
+	}
+	writeCreated(w, fmt.Sprintf("/api/v1/workouts/%d", workout.ID), workout)
+}
+
+func apiGetWorkout(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	workout, err := svc.GetWorkout(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, "fetching workout", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, workout)
+}
+
+func apiDeleteWorkout(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := svc.DeleteWorkout(r.Context(), id); err != nil {
+		writeErr(w, r, "deleting workout", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiListLifts(w http.ResponseWriter, r *http.Request) {
+	workoutID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	p, ok := parseList(w, r, service.LiftList)
+	if !ok {
+		return
+	}
+	lifts, err := svc.ListLifts(r.Context(), workoutID, p)
+	if err != nil {
+		writeErr(w, r, "fetching lifts", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, lifts)
+}
+
+func apiCreateLift(w http.ResponseWriter, r *http.Request) {
+	workoutID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req service.LiftInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	lift, err := svc.CreateLift(r.Context(), workoutID, req)
+	if err != nil {
+		writeErr(w, r, "creating lift", err)
+		return
+	}
+	writeCreated(w, fmt.Sprintf("/api/v1/lifts/%d", lift.ID), lift)
+}
+
+func apiGetLift(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	lift, err := svc.GetLift(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, "fetching lift", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, lift)
+}
+
+func apiDeleteLift(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := svc.DeleteLift(r.Context(), id); err != nil {
+		writeErr(w, r, "deleting lift", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiListMeals(w http.ResponseWriter, r *http.Request) {
+	dayID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	p, ok := parseList(w, r, service.MealList)
+	if !ok {
+		return
+	}
+	meals, err := svc.ListMeals(r.Context(), dayID, p)
+	if err != nil {
+		writeErr(w, r, "fetching meals", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meals)
+}
+
+func apiCreateMeal(w http.ResponseWriter, r *http.Request) {
+	dayID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req service.MealInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	meal, err := svc.CreateMeal(r.Context(), dayID, req)
+	if err != nil {
+		writeErr(w, r, "creating meal", err)
+		return
+	}
+	writeCreated(w, fmt.Sprintf("/api/v1/meals/%d", meal.ID), meal)
+}
+
+func apiGetMeal(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	meal, err := svc.GetMeal(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, "fetching meal", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meal)
+}
+
+func apiDeleteMeal(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := svc.DeleteMeal(r.Context(), id); err != nil {
+		writeErr(w, r, "deleting meal", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathID parses the {id} wildcard, writing a 400 problem if it is not a
+// positive integer.
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	var v service.Validator
+	id := v.ID("id", r.PathValue("id"))
+	if err := v.Err(); err != nil {
+		writeErr(w, r, "", err)
+		return 0, false
+	}
+	return id, true
+}
+
+// parseList parses the paging, sorting and filtering parameters for spec,
+// writing a 400 problem if any of them is invalid.
+func parseList[T any](w http.ResponseWriter, r *http.Request, spec service.ListSpec[T]) (service.ListParams, bool) {
+	p, err := spec.Parse(r.URL.Query())
+	if err != nil {
+		writeErr(w, r, "", err)
+		return p, false
+	}
+	return p, true
+}
+
+// decodeJSON decodes the request body into v, writing a 400 problem if the
+// body is not valid JSON.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeProblem(w, r, decodeError(err))
+		return false
+	}
+	return true
+}
+
+// decodeAndValidate decodes the body into in and runs its Validate method.
+func decodeAndValidate(w http.ResponseWriter, r *http.Request, in interface{ Validate() error }) bool {
+	if !decodeJSON(w, r, in) {
+		return false
+	}
+	if err := in.Validate(); err != nil {
+		writeErr(w, r, "", err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding response", "err", err)
+	}
+}
+
+func writeCreated(w http.ResponseWriter, location string, v any) {
+	w.Header().Set("Location", location)
+	writeJSON(w, http.StatusCreated, v)
+}
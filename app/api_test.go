@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lab8-go/service"
+)
+
+// fakeDB answers each statement with the next of its results, in order, and
+// records the arguments, so handlers can be tested without Postgres.
+type fakeDB struct {
+	results []fakeResult
+	args    [][]driver.Value
+}
+
+// fakeResult is a statement's answer: one row for a query (none if row is
+// nil) or the number of rows an exec affected.
+type fakeResult struct {
+	row      []driver.Value
+	affected int64
+}
+
+// useFakeDB points svc at a fakeDB answering with results until the test
+// ends.
+func useFakeDB(t *testing.T, results ...fakeResult) *fakeDB {
+	f := &fakeDB{results: results}
+	old := svc
+	svc = service.New(sql.OpenDB(f), time.Monday)
+	t.Cleanup(func() { svc = old })
+	return f
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+func (f *fakeDB) Prepare(string) (driver.Stmt, error) { return nil, errors.New("fakeDB: prepare") }
+func (f *fakeDB) Begin() (driver.Tx, error)           { return nil, errors.New("fakeDB: begin") }
+func (f *fakeDB) Close() error                        { return nil }
+
+func (f *fakeDB) next(args []driver.NamedValue) (fakeResult, error) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	f.args = append(f.args, values)
+	if len(f.results) == 0 {
+		return fakeResult{}, errors.New("fakeDB: unexpected statement")
+	}
+	res := f.results[0]
+	f.results = f.results[1:]
+	return res, nil
+}
+
+func (f *fakeDB) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := f.next(args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{row: res.row}, nil
+}
+
+func (f *fakeDB) ExecContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := f.next(args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+type fakeRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return make([]string, len(r.row)) }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.row == nil || r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
+
+func TestCreateAnswersCreatedWithLocation(t *testing.T) {
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	useFakeDB(t, fakeResult{row: []driver.Value{int64(7), start}})
+
+	rec := httptest.NewRecorder()
+	apiCreateWeek(rec, httptest.NewRequest("POST", "/api/v1/weeks", strings.NewReader(`{"start_date": "2024-03-04"}`)))
+
+	if rec.Code != 201 {
+		t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/api/v1/weeks/7" {
+		t.Errorf("Location %q, want /api/v1/weeks/7", loc)
+	}
+	var week service.Week
+	if err := json.Unmarshal(rec.Body.Bytes(), &week); err != nil || week.ID != 7 || week.StartDate != "2024-03-04" {
+		t.Errorf("body %s (%v), want the created week", rec.Body, err)
+	}
+}
+
+func TestDeleteAnswersNoContent(t *testing.T) {
+	f := useFakeDB(t, fakeResult{affected: 1}, fakeResult{affected: 0})
+
+	for _, want := range []int{204, 404} {
+		r := httptest.NewRequest("DELETE", "/api/v1/weeks/7", nil)
+		r.SetPathValue("id", "7")
+		rec := httptest.NewRecorder()
+		apiDeleteWeek(rec, r)
+		if rec.Code != want {
+			t.Errorf("status %d, want %d: %s", rec.Code, want, rec.Body)
+		}
+		if want == 204 && rec.Body.Len() != 0 {
+			t.Errorf("204 has a body: %s", rec.Body)
+		}
+	}
+	if len(f.args) != 2 || f.args[0][0] != int64(7) {
+		t.Errorf("deletes ran with %v, want id 7", f.args)
+	}
+}
+
+func TestPathIDRejectsNonNumericIDs(t *testing.T) {
+	// svc is left nil: a bad id must be rejected before the database.
+	for _, id := range []string{"abc", "0", "-1", "1.5", "7abc"} {
+		r := httptest.NewRequest("GET", "/api/v1/weeks/"+id, nil)
+		r.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		apiGetWeek(rec, r)
+
+		p := decodeProblem(t, rec)
+		if rec.Code != 400 || p.Code != codeValidation || strings.Join(fieldNames(p.Errors), ",") != "id" {
+			t.Errorf("id %q: status %d code %q fields %v, want a 400 for id", id, rec.Code, p.Code, fieldNames(p.Errors))
+		}
+	}
+}
+
+// The legacy endpoints have always taken day_id as either a number or a
+// numeric string.
+func TestLegacyWorkoutTakesDayIDAsString(t *testing.T) {
+	now := time.Date(2024, 3, 4, 18, 0, 0, 0, time.UTC)
+	for _, body := range []string{
+		`{"day_id": 3, "name": "Push", "duration": 60}`,
+		`{"day_id": "3", "name": "Push", "duration": 60}`,
+	} {
+		f := useFakeDB(t,
+			fakeResult{row: []driver.Value{int64(1)}}, // the day's week
+			fakeResult{row: []driver.Value{int64(11), int64(3), "Push", int64(60), now}},
+		)
+		rec := httptest.NewRecorder()
+		addWorkoutHandler(rec, httptest.NewRequest("POST", "/add-workout", strings.NewReader(body)))
+
+		if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"id":11`) {
+			t.Errorf("%s: status %d: %s", body, rec.Code, rec.Body)
+		}
+		if len(f.args) != 2 || f.args[1][0] != int64(3) {
+			t.Errorf("%s: statements ran with %v, want day 3", body, f.args)
+		}
+	}
+
+	// json.Number refuses a string that is not a number while decoding;
+	// a missing day_id is left to validation.
+	for body, code := range map[string]string{
+		`{"day_id": "abc", "name": "Push", "duration": 60}`: codeInvalidJSON,
+		`{"day_id": "", "name": "Push", "duration": 60}`:    codeInvalidJSON,
+		`{"name": "Push", "duration": 60}`:                  codeValidation,
+	} {
+		useFakeDB(t) // with no answers: the day_id must be rejected first
+		rec := httptest.NewRecorder()
+		addWorkoutHandler(rec, httptest.NewRequest("POST", "/add-workout", strings.NewReader(body)))
+		p := decodeProblem(t, rec)
+		if rec.Code != 400 || p.Code != code {
+			t.Errorf("%s: status %d code %q, want a 400 %s", body, rec.Code, p.Code, code)
+		}
+		if code == codeValidation && strings.Join(fieldNames(p.Errors), ",") != "day_id" {
+			t.Errorf("%s: fields %v, want day_id", body, fieldNames(p.Errors))
+		}
+	}
+}
+
+func TestLegacyLiftStillNeedsAWeight(t *testing.T) {
+	for weight, code := range map[string]string{"0": "must_be_positive", "-5": "must_not_be_negative"} {
+		useFakeDB(t)
+		body := `{"workout_id": 3, "name": "Pull-up", "weight": ` + weight + `, "reps": 8, "lift_order": 1}`
+		rec := httptest.NewRecorder()
+		addLiftHandler(rec, httptest.NewRequest("POST", "/add-lift", strings.NewReader(body)))
+		p := decodeProblem(t, rec)
+		if rec.Code != 400 || len(p.Errors) != 1 || p.Errors[0].Field != "weight" || p.Errors[0].Code != code {
+			t.Errorf("weight %s: status %d errors %+v, want one weight error %s", weight, rec.Code, p.Errors, code)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"lab8-go/service"
+)
+
+// The calendar invariants: weeks never overlap (so no two share a start
+// date), every day falls within the seven days of its week, and no date
+// has more than one day. Weeks have no owner yet, so uniqueness is global.
+// The service maps violations to client errors.
+const calendarSchema = `
+    CREATE OR REPLACE FUNCTION check_day_within_week() RETURNS trigger AS $$
+    BEGIN
+        IF NOT EXISTS (
+            SELECT 1 FROM weeks
+            WHERE id = NEW.week_id AND NEW.day_date BETWEEN start_date AND start_date + 6
+        ) THEN
+            RAISE EXCEPTION 'day % is outside its week', NEW.day_date
+                USING ERRCODE = 'check_violation', CONSTRAINT = 'days_within_week';
+        END IF;
+        RETURN NEW;
+    END
+    $$ LANGUAGE plpgsql;
+
+    DROP TRIGGER IF EXISTS days_within_week ON days;
+    CREATE TRIGGER days_within_week BEFORE INSERT OR UPDATE OF week_id, day_date ON days
+        FOR EACH ROW EXECUTE FUNCTION check_day_within_week();
+
+    CREATE OR REPLACE FUNCTION check_week_keeps_days() RETURNS trigger AS $$
+    BEGIN
+        IF EXISTS (
+            SELECT 1 FROM days
+            WHERE week_id = NEW.id AND day_date NOT BETWEEN NEW.start_date AND NEW.start_date + 6
+        ) THEN
+            RAISE EXCEPTION 'week % would no longer contain its days', NEW.id
+                USING ERRCODE = 'check_violation', CONSTRAINT = 'days_within_week';
+        END IF;
+        RETURN NEW;
+    END
+    $$ LANGUAGE plpgsql;
+
+    DROP TRIGGER IF EXISTS weeks_keep_days ON weeks;
+    CREATE TRIGGER weeks_keep_days BEFORE UPDATE OF start_date ON weeks
+        FOR EACH ROW EXECUTE FUNCTION check_week_keeps_days();`
+
+// calendarConstraints are added separately because they validate the
+// existing rows, which fails until violations are repaired.
+var calendarConstraints = []struct{ table, name, definition string }{
+	{"weeks", "weeks_start_date_key", "UNIQUE (start_date)"},
+	{"weeks", "weeks_no_overlap", "EXCLUDE USING gist (daterange(start_date, start_date + 7) WITH &&)"},
+	{"days", "days_day_date_key", "UNIQUE (day_date)"},
+}
+
+// initCalendar installs the calendar invariants. A constraint the
+// existing data violates is skipped with a warning rather than stopping
+// the server; repair-calendar fixes the data.
+func initCalendar() {
+	if _, err := db.Exec(calendarSchema); err != nil {
+		log.Fatal(err)
+	}
+	if missing := addCalendarConstraints(); len(missing) > 0 {
+		slog.Warn("Calendar constraints are not enforced because existing rows violate them; run `repair-calendar -fix`",
+			"constraints", strings.Join(missing, ", "))
+	}
+}
+
+// addCalendarConstraints adds the constraints that do not exist yet and
+// returns the names of those that could not be added.
+func addCalendarConstraints() []string {
+	var missing []string
+	for _, c := range calendarConstraints {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = $1)", c.name).Scan(&exists); err != nil {
+			log.Fatal(err)
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", c.table, c.name, c.definition)); err != nil {
+			slog.Warn("Error adding constraint", "constraint", c.name, "err", err)
+			missing = append(missing, c.name)
+		}
+	}
+	return missing
+}
+
+// addLogRoutes registers the date-first logging endpoints. They take a
+// calendar date in the path and find or create the week and day for it,
+// so clients never have to look up ids first.
+func addLogRoutes() {
+	handleJSON("POST /log/{date}/workouts", logWorkoutHandler)
+	handleJSON("POST /log/{date}/meals", logMealHandler)
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) || strings.EqualFold(s, d.String()[:3]) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", s)
+}
+
+// pathDate parses the {date} path value, writing a 400 problem if it is
+// not a YYYY-MM-DD date.
+func pathDate(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	var v service.Validator
+	s := r.PathValue("date")
+	v.Date("date", s)
+	if err := v.Err(); err != nil {
+		writeErr(w, r, "", err)
+		return time.Time{}, false
+	}
+	date, _ := time.Parse(service.DateLayout, s)
+	return date, true
+}
+
+func logWorkoutHandler(w http.ResponseWriter, r *http.Request) {
+	date, ok := pathDate(w, r)
+	if !ok {
+		return
+	}
+	var req service.WorkoutInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	workout, err := svc.LogWorkout(r.Context(), date, req)
+	if err != nil {
+		writeErr(w, r, "logging workout", err)
+		return
+	}
+	writeCreated(w, fmt.Sprintf("/api/v1/workouts/%d", workout.ID), workout)
+}
+
+func logMealHandler(w http.ResponseWriter, r *http.Request) {
+	date, ok := pathDate(w, r)
+	if !ok {
+		return
+	}
+	var req service.MealInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	meal, err := svc.LogMeal(r.Context(), date, req)
+	if err != nil {
+		writeErr(w, r, "logging meal", err)
+		return
+	}
+	writeCreated(w, fmt.Sprintf("/api/v1/meals/%d", meal.ID), meal)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// svgChart is a line chart laid out in Go so the template only has to
+// draw it: every coordinate is already in SVG units.
+type svgChart struct {
+	Title   string
+	Width   int
+	Height  int
+	Left    int // x of the y axis
+	Bottom  int // y of the x axis
+	Right   int // x where the plot ends
+	Lines   []svgLine
+	XLabels []svgLabel
+	YLabels []svgLabel
+}
+
+type svgLine struct {
+	Name   string
+	Class  string
+	Points string // "x,y x,y ..." for <polyline points>
+}
+
+type svgLabel struct {
+	X, Y int
+	Text string
+}
+
+// chartLine picks one value of each point to draw.
+type chartLine struct {
+	Name  string
+	Class string
+	Value func(seriesPoint) float64
+}
+
+const (
+	chartWidth   = 720
+	chartHeight  = 220
+	chartLeft    = 56
+	chartRight   = 12
+	chartTop     = 12
+	chartBottom  = 28
+	chartXLabels = 8
+)
+
+// lineChart lays out one line per chartLine over the points, scaling the
+// y axis from zero to a round number above the highest value. unit is
+// appended to the y labels.
+func lineChart(title, unit string, points []seriesPoint, interval string, lines ...chartLine) svgChart {
+	c := svgChart{
+		Title:  title,
+		Width:  chartWidth,
+		Height: chartHeight,
+		Left:   chartLeft,
+		Bottom: chartHeight - chartBottom,
+		Right:  chartWidth - chartRight,
+	}
+	plotW := float64(c.Right - c.Left)
+	plotH := float64(c.Bottom - chartTop)
+
+	maxY := 0.0
+	for _, p := range points {
+		for _, l := range lines {
+			maxY = math.Max(maxY, l.Value(p))
+		}
+	}
+	maxY = niceCeil(maxY)
+
+	x := func(i int) float64 {
+		if len(points) < 2 {
+			return float64(c.Left) + plotW/2
+		}
+		return float64(c.Left) + plotW*float64(i)/float64(len(points)-1)
+	}
+	y := func(v float64) float64 {
+		return float64(c.Bottom) - plotH*v/maxY
+	}
+
+	for _, l := range lines {
+		coords := make([]string, len(points))
+		for i, p := range points {
+			coords[i] = fmt.Sprintf("%.1f,%.1f", x(i), y(l.Value(p)))
+		}
+		c.Lines = append(c.Lines, svgLine{Name: l.Name, Class: l.Class, Points: strings.Join(coords, " ")})
+	}
+
+	for _, v := range []float64{0, maxY / 2, maxY} {
+		c.YLabels = append(c.YLabels, svgLabel{X: c.Left - 6, Y: int(y(v)) + 4, Text: formatTick(v) + unit})
+	}
+
+	layout := "Jan 2"
+	if interval == "hour" {
+		layout = "Jan 2 15:04"
+	}
+	every := max(1, (len(points)+chartXLabels-1)/chartXLabels)
+	for i := 0; i < len(points); i += every {
+		c.XLabels = append(c.XLabels, svgLabel{X: int(x(i)), Y: c.Bottom + 18, Text: points[i].Time.Format(layout)})
+	}
+	return c
+}
+
+// niceCeil rounds v up to 1, 2 or 5 times a power of ten, and is at least
+// 1 so an empty chart still has an axis.
+func niceCeil(v float64) float64 {
+	if v <= 1 {
+		return 1
+	}
+	pow := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*pow {
+			return m * pow
+		}
+	}
+	return 10 * pow
+}
+
+func formatTick(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// minCompressSize is the smallest response worth compressing when its
+// length is known up front.
+const minCompressSize = 1024
+
+// encoders are the content codings compress offers, in order of
+// preference when the client accepts several equally.
+var encoders = []struct {
+	name string
+	pool *sync.Pool
+}{
+	{"br", &sync.Pool{New: func() any { return brotli.NewWriterLevel(nil, 4) }}},
+	{"gzip", &sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}},
+}
+
+type resetWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compress encodes text responses (pages, JSON, scripts, styles, SVG) with
+// br or gzip when the client accepts it. Event streams, already encoded
+// responses and known-small ones are sent as they are.
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding < 0 || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding returns the index in encoders of the coding the client
+// prefers, or -1 for none.
+func negotiateEncoding(header string) int {
+	best, bestQ := -1, 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		for i, e := range encoders {
+			if strings.EqualFold(strings.TrimSpace(name), e.name) && q > 0 &&
+				(q > bestQ || (q == bestQ && i < best)) {
+				best, bestQ = i, q
+			}
+		}
+	}
+	return best
+}
+
+// compressWriter decides when the header is written whether to compress,
+// and from then on either encodes or passes writes through.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    int
+	enc         resetWriter
+	wroteHeader bool
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader || status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	if w.shouldCompress(status) {
+		h := w.Header()
+		h.Set("Content-Encoding", encoders[w.encoding].name)
+		h.Del("Content-Length")
+		w.enc = encoders[w.encoding].pool.Get().(resetWriter)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) shouldCompress(status int) bool {
+	h := w.Header()
+	if status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent ||
+		h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minCompressSize {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch mediaType {
+	case "text/event-stream":
+		return false
+	case "application/json", "application/problem+json", "application/javascript", "image/svg+xml":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.enc.Write(b)
+}
+
+// Flush sends what has been encoded so far, for responses written in
+// parts.
+func (w *compressWriter) Flush() {
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if w.enc == nil {
+		return
+	}
+	w.enc.Close()
+	w.enc.Reset(io.Discard)
+	encoders[w.encoding].pool.Put(w.enc)
+	w.enc = nil
+}
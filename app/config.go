@@ -0,0 +1,529 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is every setting the server reads at startup. Each setting can
+// come from a command-line flag, an environment variable or the config
+// file, in that order of precedence, and falls back to the default in
+// defaultConfig. The settings method lists them.
+type Config struct {
+	ConfigFile string
+
+	HTTP struct {
+		Address           string
+		Port              int
+		ReadHeaderTimeout time.Duration
+		ReadTimeout       time.Duration
+		WriteTimeout      time.Duration
+		IdleTimeout       time.Duration
+		ShutdownTimeout   time.Duration
+		MaxBodyBytes      int
+		Compress          bool
+	}
+
+	DB struct {
+		DSN             string // overrides the individual fields when set
+		Host            string
+		Port            int
+		User            string
+		Password        string
+		Name            string
+		SSLMode         string
+		MaxOpenConns    int
+		MaxIdleConns    int
+		ConnMaxLifetime time.Duration
+		ConnectTimeout  time.Duration
+	}
+
+	LogLevel  string
+	LogFormat string
+
+	TLS struct {
+		CertFile string
+		KeyFile  string
+	}
+
+	CORS struct {
+		AllowedOrigins string // comma-separated; "*" allows any origin
+		MaxAge         time.Duration
+	}
+
+	Security struct {
+		CSP string
+	}
+
+	RateLimit struct {
+		Enabled        bool
+		Store          string // "memory" or "postgres"
+		ReadPerMinute  int
+		ReadBurst      int
+		WritePerMinute int
+		WriteBurst     int
+		KeyHeader      string
+	}
+
+	Features struct {
+		GraphQL   bool
+		Docs      bool
+		Analytics bool
+		Metrics   bool
+	}
+
+	Analytics struct {
+		FlushInterval time.Duration
+		Retention     time.Duration
+	}
+
+	WeekStart      string
+	IdempotencyTTL time.Duration
+	Dev            bool
+}
+
+func defaultConfig() *Config {
+	c := &Config{}
+	c.HTTP.Address = "0.0.0.0"
+	c.HTTP.Port = 8080
+	c.HTTP.ReadHeaderTimeout = 5 * time.Second
+	c.HTTP.ReadTimeout = 15 * time.Second
+	c.HTTP.WriteTimeout = 30 * time.Second
+	c.HTTP.IdleTimeout = 2 * time.Minute
+	c.HTTP.ShutdownTimeout = 20 * time.Second
+	c.HTTP.MaxBodyBytes = 1 << 20
+	c.HTTP.Compress = true
+	c.DB.Host = "host.docker.internal"
+	c.DB.Port = 5432
+	c.DB.User = "postgres"
+	c.DB.Password = "password"
+	c.DB.Name = "testdb"
+	c.DB.SSLMode = "disable"
+	c.DB.MaxOpenConns = 25
+	c.DB.MaxIdleConns = 5
+	c.DB.ConnMaxLifetime = 30 * time.Minute
+	c.DB.ConnectTimeout = time.Minute
+	c.LogLevel = "info"
+	c.LogFormat = "text"
+	c.CORS.MaxAge = 10 * time.Minute
+	c.Security.CSP = defaultCSP
+	c.RateLimit.Enabled = true
+	c.RateLimit.Store = "memory"
+	c.RateLimit.ReadPerMinute = 600
+	c.RateLimit.ReadBurst = 100
+	c.RateLimit.WritePerMinute = 120
+	c.RateLimit.WriteBurst = 20
+	c.Features.GraphQL = true
+	c.Features.Docs = true
+	c.Features.Analytics = true
+	c.Features.Metrics = true
+	c.Analytics.FlushInterval = 10 * time.Second
+	c.Analytics.Retention = 90 * 24 * time.Hour
+	c.WeekStart = "monday"
+	c.IdempotencyTTL = 24 * time.Hour
+	return c
+}
+
+// setting describes one configuration value: its key in the config file
+// ("db.max_open_conns"), which is also its flag name with dots and
+// underscores turned into dashes ("-db-max-open-conns"), and its
+// environment variable.
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	value  flag.Value
+	source string // "default", "file", "env" or "flag", for config show
+}
+
+func (c *Config) settings() []*setting {
+	return []*setting{
+		{key: "config", env: "CONFIG_FILE", usage: "path of a TOML or YAML config file", value: stringVar{&c.ConfigFile}},
+
+		{key: "http.address", env: "HTTP_ADDRESS", usage: "IP address or host name to listen on", value: stringVar{&c.HTTP.Address}},
+		{key: "http.port", env: "HTTP_PORT", usage: "port to listen on", value: intVar{&c.HTTP.Port}},
+		{key: "http.read_header_timeout", env: "HTTP_READ_HEADER_TIMEOUT", usage: "maximum time to read request headers", value: durationVar{&c.HTTP.ReadHeaderTimeout}},
+		{key: "http.read_timeout", env: "HTTP_READ_TIMEOUT", usage: "maximum time to read a request", value: durationVar{&c.HTTP.ReadTimeout}},
+		{key: "http.write_timeout", env: "HTTP_WRITE_TIMEOUT", usage: "maximum time to write a response", value: durationVar{&c.HTTP.WriteTimeout}},
+		{key: "http.idle_timeout", env: "HTTP_IDLE_TIMEOUT", usage: "how long idle keep-alive connections are kept", value: durationVar{&c.HTTP.IdleTimeout}},
+		{key: "http.shutdown_timeout", env: "HTTP_SHUTDOWN_TIMEOUT", usage: "how long to wait for in-flight requests on shutdown", value: durationVar{&c.HTTP.ShutdownTimeout}},
+		{key: "http.max_body_bytes", env: "HTTP_MAX_BODY_BYTES", usage: "largest request body accepted", value: intVar{&c.HTTP.MaxBodyBytes}},
+		{key: "http.compress", env: "HTTP_COMPRESS", usage: "compress responses with br or gzip when the client accepts it", value: boolVar{&c.HTTP.Compress}},
+
+		{key: "db.dsn", env: "DB_DSN", usage: "full Postgres connection string; overrides the other db settings", secret: true, value: stringVar{&c.DB.DSN}},
+		{key: "db.host", env: "DB_HOST", usage: "database host", value: stringVar{&c.DB.Host}},
+		{key: "db.port", env: "DB_PORT", usage: "database port", value: intVar{&c.DB.Port}},
+		{key: "db.user", env: "DB_USER", usage: "database user", value: stringVar{&c.DB.User}},
+		{key: "db.password", env: "DB_PASSWORD", usage: "database password", secret: true, value: stringVar{&c.DB.Password}},
+		{key: "db.name", env: "DB_NAME", usage: "database name", value: stringVar{&c.DB.Name}},
+		{key: "db.sslmode", env: "DB_SSLMODE", usage: "disable, require, verify-ca or verify-full", value: stringVar{&c.DB.SSLMode}},
+		{key: "db.max_open_conns", env: "DB_MAX_OPEN_CONNS", usage: "maximum open connections, 0 for no limit", value: intVar{&c.DB.MaxOpenConns}},
+		{key: "db.max_idle_conns", env: "DB_MAX_IDLE_CONNS", usage: "maximum idle connections", value: intVar{&c.DB.MaxIdleConns}},
+		{key: "db.conn_max_lifetime", env: "DB_CONN_MAX_LIFETIME", usage: "maximum age of a connection, 0 for no limit", value: durationVar{&c.DB.ConnMaxLifetime}},
+		{key: "db.connect_timeout", env: "DB_CONNECT_TIMEOUT", usage: "how long to keep retrying the database at startup", value: durationVar{&c.DB.ConnectTimeout}},
+
+		{key: "log_level", env: "LOG_LEVEL", usage: "debug, info, warn or error", value: stringVar{&c.LogLevel}},
+		{key: "log_format", env: "LOG_FORMAT", usage: "text or json", value: stringVar{&c.LogFormat}},
+
+		{key: "tls.cert_file", env: "TLS_CERT_FILE", usage: "TLS certificate; serves HTTPS when set together with tls.key_file", value: stringVar{&c.TLS.CertFile}},
+		{key: "tls.key_file", env: "TLS_KEY_FILE", usage: "TLS private key", value: stringVar{&c.TLS.KeyFile}},
+
+		{key: "cors.allowed_origins", env: "CORS_ALLOWED_ORIGINS", usage: "comma-separated origins allowed to call the JSON API from a browser, or *", value: stringVar{&c.CORS.AllowedOrigins}},
+		{key: "cors.max_age", env: "CORS_MAX_AGE", usage: "how long browsers may cache a preflight response", value: durationVar{&c.CORS.MaxAge}},
+
+		{key: "security.csp", env: "SECURITY_CSP", usage: "Content-Security-Policy header for every response", value: stringVar{&c.Security.CSP}},
+
+		{key: "ratelimit.enabled", env: "RATELIMIT_ENABLED", usage: "limit how fast each client can make requests", value: boolVar{&c.RateLimit.Enabled}},
+		{key: "ratelimit.store", env: "RATELIMIT_STORE", usage: "memory, or postgres to share limits between instances", value: stringVar{&c.RateLimit.Store}},
+		{key: "ratelimit.read_per_minute", env: "RATELIMIT_READ_PER_MINUTE", usage: "sustained GET requests per minute per client", value: intVar{&c.RateLimit.ReadPerMinute}},
+		{key: "ratelimit.read_burst", env: "RATELIMIT_READ_BURST", usage: "GET requests a client can make at once", value: intVar{&c.RateLimit.ReadBurst}},
+		{key: "ratelimit.write_per_minute", env: "RATELIMIT_WRITE_PER_MINUTE", usage: "sustained POST, PUT, PATCH and DELETE requests per minute per client", value: intVar{&c.RateLimit.WritePerMinute}},
+		{key: "ratelimit.write_burst", env: "RATELIMIT_WRITE_BURST", usage: "writes a client can make at once", value: intVar{&c.RateLimit.WriteBurst}},
+		{key: "ratelimit.key_header", env: "RATELIMIT_KEY_HEADER", usage: "header identifying API clients, set by a trusted gateway; clients without it are limited by IP", value: stringVar{&c.RateLimit.KeyHeader}},
+
+		{key: "features.graphql", env: "FEATURE_GRAPHQL", usage: "serve /graphql", value: boolVar{&c.Features.GraphQL}},
+		{key: "features.docs", env: "FEATURE_DOCS", usage: "serve /openapi.json and /docs", value: boolVar{&c.Features.Docs}},
+		{key: "features.analytics", env: "FEATURE_ANALYTICS", usage: "count endpoint visits and serve /analytics", value: boolVar{&c.Features.Analytics}},
+		{key: "features.metrics", env: "FEATURE_METRICS", usage: "serve Prometheus metrics at /metrics", value: boolVar{&c.Features.Metrics}},
+
+		{key: "analytics.flush_interval", env: "ANALYTICS_FLUSH_INTERVAL", usage: "how often counted visits are written to the database", value: durationVar{&c.Analytics.FlushInterval}},
+		{key: "analytics.retention", env: "ANALYTICS_RETENTION", usage: "how long hourly route statistics are kept", value: durationVar{&c.Analytics.Retention}},
+
+		{key: "week_start", env: "WEEK_START", usage: "first day of a new week", value: stringVar{&c.WeekStart}},
+		{key: "idempotency_ttl", env: "IDEMPOTENCY_TTL", usage: "how long an Idempotency-Key is honored", value: durationVar{&c.IdempotencyTTL}},
+		{key: "dev", env: "DEV", usage: "serve templates and static files from disk, reloading them on every request", value: boolVar{&c.Dev}},
+	}
+}
+
+func (s *setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+// loadConfig resolves the configuration from the command line args (without
+// the program name), the environment and the config file, validates it,
+// and returns it with the arguments left after the flags.
+func loadConfig(args []string, getenv func(string) string) (*Config, []*setting, []string, error) {
+	cfg := defaultConfig()
+	settings := cfg.settings()
+	for _, s := range settings {
+		s.source = "default"
+	}
+
+	// Flags are parsed first so -config is known, but applied last.
+	flags := flag.NewFlagSet("lab8-go", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	fromFlags := map[*setting]string{}
+	for _, s := range settings {
+		flags.Var(&recorder{s, fromFlags}, s.flagName(), s.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flags.SetOutput(os.Stderr)
+			flags.PrintDefaults()
+		}
+		return nil, nil, nil, err
+	}
+
+	path := getenv("CONFIG_FILE")
+	if s := settings[0]; fromFlags[s] != "" {
+		path = fromFlags[s]
+	}
+	if path != "" {
+		if err := cfg.loadFile(path, settings); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.value.Set(v); err != nil {
+				return nil, nil, nil, fmt.Errorf("%s: %v", s.env, err)
+			}
+			s.source = "env " + s.env
+		}
+	}
+	for _, s := range settings {
+		if v, ok := fromFlags[s]; ok {
+			s.value.Set(v) // already checked while parsing
+			s.source = "flag -" + s.flagName()
+		}
+	}
+
+	return cfg, settings, flags.Args(), cfg.validate()
+}
+
+// loadFile applies a TOML (.toml) or YAML (.yaml, .yml) file. Sections in
+// the file become the first part of the key, so [db] max_open_conns = 10
+// sets db.max_open_conns. Unknown keys are an error, so typos are caught.
+func (c *Config) loadFile(path string, settings []*setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	var raw map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("config file %s: must end in .toml, .yaml or .yml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", raw, values)
+	byKey := map[string]*setting{}
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	for key, v := range values {
+		s, ok := byKey[key]
+		if !ok || s.key == "config" {
+			return fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+		if err := s.value.Set(v); err != nil {
+			return fmt.Errorf("config file %s: %s: %v", path, key, err)
+		}
+		s.source = "file"
+	}
+	return nil
+}
+
+func flatten(prefix string, raw map[string]any, out map[string]string) {
+	for k, v := range raw {
+		key := prefix + k
+		if section, ok := v.(map[string]any); ok {
+			flatten(key+".", section, out)
+			continue
+		}
+		out[key] = fmt.Sprint(v)
+	}
+}
+
+var (
+	logLevels  = []string{"debug", "info", "warn", "error"}
+	logFormats = []string{"text", "json"}
+)
+
+// validate reports every invalid setting at once.
+func (c *Config) validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.HTTP.Address != "" && !strings.ContainsAny(c.HTTP.Address, " :/") || net.ParseIP(c.HTTP.Address) != nil,
+		"http.address %q must be an IP address or host name", c.HTTP.Address)
+	check(c.HTTP.Port > 0 && c.HTTP.Port <= 65535, "http.port must be between 1 and 65535")
+	check(c.HTTP.ReadHeaderTimeout > 0, "http.read_header_timeout must be positive")
+	check(c.HTTP.ReadTimeout > 0, "http.read_timeout must be positive")
+	check(c.HTTP.WriteTimeout > 0, "http.write_timeout must be positive")
+	check(c.HTTP.IdleTimeout > 0, "http.idle_timeout must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout must be positive")
+	check(c.HTTP.MaxBodyBytes > 0, "http.max_body_bytes must be positive")
+
+	if c.DB.DSN == "" {
+		check(c.DB.Host != "", "db.host is required unless db.dsn is set")
+		check(c.DB.Name != "", "db.name is required unless db.dsn is set")
+		check(c.DB.Port > 0 && c.DB.Port <= 65535, "db.port must be between 1 and 65535")
+		check(oneOf(c.DB.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+			"db.sslmode %q must be disable, allow, prefer, require, verify-ca or verify-full", c.DB.SSLMode)
+	}
+	check(c.DB.MaxOpenConns >= 0, "db.max_open_conns must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "db.max_idle_conns must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "db.max_idle_conns must not exceed db.max_open_conns")
+	check(c.DB.ConnMaxLifetime >= 0, "db.conn_max_lifetime must not be negative")
+	check(c.DB.ConnectTimeout > 0, "db.connect_timeout must be positive")
+
+	check(oneOf(c.LogLevel, logLevels...), "log_level %q must be one of %s", c.LogLevel, strings.Join(logLevels, ", "))
+	check(oneOf(c.LogFormat, logFormats...), "log_format %q must be one of %s", c.LogFormat, strings.Join(logFormats, ", "))
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.cert_file and tls.key_file must be set together")
+	for _, f := range []struct{ key, path string }{{"tls.cert_file", c.TLS.CertFile}, {"tls.key_file", c.TLS.KeyFile}} {
+		if f.path != "" {
+			_, err := os.Stat(f.path)
+			check(err == nil, "%s: %v", f.key, err)
+		}
+	}
+
+	for _, origin := range c.corsOrigins() {
+		u, err := url.Parse(origin)
+		check(origin == "*" || (err == nil && u.Scheme != "" && u.Host != "" && u.Path == ""),
+			"cors.allowed_origins: %q must be * or an origin such as https://example.com", origin)
+	}
+	check(c.CORS.MaxAge >= 0, "cors.max_age must not be negative")
+
+	check(oneOf(c.RateLimit.Store, "memory", "postgres"), "ratelimit.store %q must be memory or postgres", c.RateLimit.Store)
+	check(c.RateLimit.ReadPerMinute > 0 && c.RateLimit.WritePerMinute > 0, "ratelimit.read_per_minute and ratelimit.write_per_minute must be positive")
+	check(c.RateLimit.ReadBurst > 0 && c.RateLimit.WriteBurst > 0, "ratelimit.read_burst and ratelimit.write_burst must be positive")
+
+	_, err := parseWeekday(c.WeekStart)
+	check(err == nil, "week_start %q must be a weekday such as monday", c.WeekStart)
+	check(c.Analytics.FlushInterval > 0, "analytics.flush_interval must be positive")
+	check(c.Analytics.Retention >= time.Hour, "analytics.retention must be at least 1h")
+	check(c.IdempotencyTTL > 0, "idempotency_ttl must be positive")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// defaultCSP allows only this origin. The pages use inline scripts, event
+// handler attributes and styles, so those are allowed inline.
+const defaultCSP = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; frame-ancestors 'none'; base-uri 'self'; form-action 'self'"
+
+// corsOrigins returns cors.allowed_origins as a list.
+func (c *Config) corsOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(c.CORS.AllowedOrigins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, strings.TrimSuffix(o, "/"))
+		}
+	}
+	return origins
+}
+
+func oneOf(s string, options ...string) bool {
+	for _, o := range options {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
+
+// dsn returns the Postgres connection string.
+func (c *Config) dsn() string {
+	if c.DB.DSN != "" {
+		return c.DB.DSN
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		dsnValue(c.DB.Host), c.DB.Port, dsnValue(c.DB.User), dsnValue(c.DB.Password), dsnValue(c.DB.Name), c.DB.SSLMode)
+}
+
+// dsnValue quotes a keyword/value connection string value.
+func dsnValue(s string) string {
+	if s != "" && !strings.ContainsAny(s, ` '\`) {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// listenAddr is the host:port the server binds to.
+func (c *Config) listenAddr() string {
+	return net.JoinHostPort(c.HTTP.Address, strconv.Itoa(c.HTTP.Port))
+}
+
+var dsnPassword = regexp.MustCompile(`(password=)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// showConfig prints every setting with its value and where it came from.
+// Secrets are masked; a DSN keeps everything but its password.
+func showConfig(w io.Writer, settings []*setting) {
+	for _, s := range settings {
+		v := s.value.String()
+		if s.secret && v != "" {
+			v = maskSecret(s.key, v)
+		}
+		fmt.Fprintf(w, "%-22s = %-30s # %s\n", s.key, strconv.Quote(v), s.source)
+	}
+}
+
+func maskSecret(key, v string) string {
+	if key != "db.dsn" {
+		return "********"
+	}
+	if u, err := url.Parse(v); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(v, "${1}xxxxx")
+}
+
+// recorder is the flag.Value registered for a setting. It checks the
+// flag's value but only records it, so loadConfig can apply flags after
+// the file and environment.
+type recorder struct {
+	s      *setting
+	values map[*setting]string
+}
+
+func (r *recorder) String() string {
+	if r == nil || r.s == nil {
+		return ""
+	}
+	return r.s.value.String()
+}
+
+func (r *recorder) Set(v string) error {
+	if err := r.s.value.Set(v); err != nil {
+		return err
+	}
+	r.values[r.s] = v
+	return nil
+}
+
+func (r *recorder) IsBoolFlag() bool {
+	_, ok := r.s.value.(boolVar)
+	return ok
+}
+
+type stringVar struct{ p *string }
+
+func (v stringVar) String() string     { return *v.p }
+func (v stringVar) Set(s string) error { *v.p = s; return nil }
+
+type intVar struct{ p *int }
+
+func (v intVar) String() string { return strconv.Itoa(*v.p) }
+func (v intVar) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v.p = n
+	return nil
+}
+
+type boolVar struct{ p *bool }
+
+func (v boolVar) String() string { return strconv.FormatBool(*v.p) }
+func (v boolVar) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", s)
+	}
+	*v.p = b
+	return nil
+}
+
+type durationVar struct{ p *time.Duration }
+
+func (v durationVar) String() string { return v.p.String() }
+func (v durationVar) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration such as 30s or 5m", s)
+	}
+	*v.p = d
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.toml")
+	os.WriteFile(file, []byte(`
+log_level = "warn"
+
+[http]
+port = 9000
+read_timeout = "5s"
+
+[db]
+host = "file-host"
+max_open_conns = 10
+`), 0o600)
+
+	env := map[string]string{"CONFIG_FILE": file, "HTTP_PORT": "9100", "DB_HOST": "env-host"}
+	cfg, _, args, err := loadConfig([]string{"-http-port", "9200", "repair-calendar", "-fix"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.HTTP.Port != 9200 {
+		t.Errorf("http.port = %d, want the flag's 9200", cfg.HTTP.Port)
+	}
+	if cfg.DB.Host != "env-host" {
+		t.Errorf("db.host = %q, want the env's env-host", cfg.DB.Host)
+	}
+	if cfg.DB.MaxOpenConns != 10 || cfg.HTTP.ReadTimeout != 5*time.Second || cfg.LogLevel != "warn" {
+		t.Errorf("file settings not applied: %+v", cfg)
+	}
+	if cfg.DB.Name != "testdb" {
+		t.Errorf("db.name = %q, want the default", cfg.DB.Name)
+	}
+	if strings.Join(args, " ") != "repair-calendar -fix" {
+		t.Errorf("args = %q", args)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	env := map[string]string{"HTTP_PORT": "0", "LOG_LEVEL": "loud", "TLS_CERT_FILE": "cert.pem"}
+	_, _, _, err := loadConfig(nil, func(k string) string { return env[k] })
+	if err == nil {
+		t.Fatal("invalid config was accepted")
+	}
+	for _, want := range []string{"http.port", "log_level", "tls.cert_file and tls.key_file"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestConfigShowMasksSecrets(t *testing.T) {
+	env := map[string]string{"DB_PASSWORD": "hunter2", "DB_DSN": "postgres://app:s3cret@db:5432/app"}
+	_, settings, _, err := loadConfig(nil, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	showConfig(&out, settings)
+	if strings.Contains(out.String(), "hunter2") || strings.Contains(out.String(), "s3cret") {
+		t.Errorf("config show leaks a secret:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "postgres://app:xxxxx@db:5432/app") {
+		t.Errorf("masked DSN lost its other parts:\n%s", out.String())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"mime"
+	"net/http"
+)
+
+// CSRF protection uses double-submit tokens: a browser is given a random
+// token in the csrf_token cookie, pages carry the same token in the
+// csrf-token meta tag, and every state-changing request from a browser
+// must send it back in the X-CSRF-Token header or the csrf_token form
+// field. Another site can make a browser send a request, but cannot read
+// the token to put in it.
+const (
+	csrfCookie = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "csrf_token"
+
+	codeCSRFFailed = "csrf_failed"
+)
+
+// csrfFuncs declares the csrfToken template function. Pages are parsed
+// with this placeholder, and renderPageStatus binds it to the token of
+// the request being rendered.
+var csrfFuncs = template.FuncMap{"csrfToken": func() string { return "" }}
+
+// csrfToken returns the request's token from its cookie, or sets a new
+// cookie and returns that token.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == base64.RawURLEncoding.EncodedLen(32) {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cfg.TLS.CertFile != "",
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// csrfProtect rejects POST, PUT, PATCH and DELETE requests from a browser
+// whose token is missing or does not match its cookie, with a 403
+// problem. Requests are from a browser when they carry an Origin,
+// Sec-Fetch-Site or Cookie header; API clients such as curl send none of
+// these and need no token. Pages on the origins in cors.allowed_origins
+// (but not "*") are trusted too, since they cannot see the token.
+func csrfProtect(origins []string) middleware {
+	trusted := map[string]bool{}
+	for _, o := range origins {
+		if o != "*" {
+			trusted[o] = true
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				next.ServeHTTP(w, r)
+				return
+			}
+			origin := r.Header.Get("Origin")
+			if trusted[origin] ||
+				(origin == "" && r.Header.Get("Sec-Fetch-Site") == "" && r.Header.Get("Cookie") == "") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !validCSRFToken(r) {
+				writeProblem(w, r, newProblem(http.StatusForbidden, codeCSRFFailed,
+					"The request is missing its CSRF token or the token is wrong; reload the page and try again."))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// validCSRFToken reports whether the request sends back the token in its
+// cookie, in the header or, for form posts, the form field.
+func validCSRFToken(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	sent := r.Header.Get(csrfHeader)
+	if sent == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data" {
+			sent = r.PostFormValue(csrfField)
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(sent), []byte(c.Value)) == 1
+}
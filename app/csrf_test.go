@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFTokenInPages(t *testing.T) {
+	cfg = defaultConfig()
+	initTemplates()
+
+	rec := httptest.NewRecorder()
+	renderPage(rec, httptest.NewRequest("GET", "/", nil), "index", nil)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %v, want one HttpOnly %s cookie", cookies, csrfCookie)
+	}
+	token := cookies[0].Value
+	if !strings.Contains(rec.Body.String(), `<meta name="csrf-token" content="`+token+`">`) {
+		t.Errorf("page does not carry the token %s:\n%s", token, rec.Body)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	renderPage(rec, req, "index", nil)
+	if len(rec.Result().Cookies()) != 0 || !strings.Contains(rec.Body.String(), token) {
+		t.Errorf("second page: cookies %v; want the existing token reused", rec.Result().Cookies())
+	}
+}
+
+func TestCSRFProtect(t *testing.T) {
+	handler := csrfProtect([]string{"https://app.example.com", "*"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	cookie := &http.Cookie{Name: csrfCookie, Value: "secret"}
+	form := url.Values{csrfField: {"secret"}}.Encode()
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		body   string
+		header map[string]string
+		cookie bool
+		want   int
+	}{
+		{"read", "GET", "", map[string]string{"Origin": "https://evil.example"}, false, 204},
+		{"api client", "POST", "", nil, false, 204},
+		{"cross-site post", "POST", "", map[string]string{"Origin": "https://evil.example"}, false, 403},
+		{"no token", "DELETE", "", map[string]string{"Sec-Fetch-Site": "same-origin"}, true, 403},
+		{"wrong token", "POST", "", map[string]string{"X-CSRF-Token": "guess"}, true, 403},
+		{"header token", "POST", "", map[string]string{"X-CSRF-Token": "secret"}, true, 204},
+		{"form token", "POST", form, map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, true, 204},
+		{"trusted origin", "POST", "", map[string]string{"Origin": "https://app.example.com"}, false, 204},
+	} {
+		req := httptest.NewRequest(tc.method, "/add-week", strings.NewReader(tc.body))
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+		if tc.cookie {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+		}
+	}
+}
@@ -2,344 +2,274 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 
-	//"strconv"
-	"time"
-	//"strconv"
+	"lab8-go/service"
 )
 
+// addEndpoints registers the original RPC-style JSON endpoints. They are
+// kept as thin shims over the same service calls the /api/v1 routes use,
+// so existing clients keep working.
 func addEndpoints() {
-	http.HandleFunc("/add-workout", addWorkoutHandler)
-	http.HandleFunc("/list-workouts", listWorkoutsHandler)
-	http.HandleFunc("/add-lift", addLiftHandler)
-	http.HandleFunc("/list-lifts", listLiftsHandler)
-    http.HandleFunc("/delete-workout", deleteWorkoutHandler)
-	http.HandleFunc("/add-week", addWeekHandler)
-    http.HandleFunc("/add-day", addDayHandler)
-    http.HandleFunc("/add-meal", addMealHandler)
+	handleJSON("POST /add-workout", addWorkoutHandler)
+	handleJSON("GET /list-workouts", listWorkoutsHandler)
+	handleJSON("POST /add-lift", addLiftHandler)
+	handleJSON("GET /list-lifts", listLiftsHandler)
+	handleJSON("POST /delete-workout", deleteWorkoutHandler)
+	handleJSON("POST /add-week", addWeekHandler)
+	handleJSON("POST /add-day", addDayHandler)
+	handleJSON("POST /add-meal", addMealHandler)
 }
 
-type Workout struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Duration int    `json:"duration"`
-	Time     time.Time `json:"time"`
+// jsonRoutes records every pattern registered through handleJSON so the
+// OpenAPI document can be checked against what is actually served.
+var jsonRoutes []string
+
+// handleJSON registers a JSON API handler on the default mux. POST routes
+// honor the Idempotency-Key header.
+func handleJSON(pattern string, handler http.HandlerFunc) {
+	jsonRoutes = append(jsonRoutes, pattern)
+	if isCreateRoute(pattern) {
+		handler = idempotent(handler)
+	}
+	http.HandleFunc(pattern, handler)
 }
 
-type Lift struct {
-    WorkoutID int     `json:"workout_id"`
-    Name      string  `json:"name"`
-    Weight    float64 `json:"weight"`
-    Reps      int     `json:"reps"`
-    LiftOrder int     `json:"lift_order"`
-    RestTime  int     `json:"rest_time"`
-    BPM       int     `json:"bpm"`
+// day_id and id have historically been sent as strings; json.Number
+// accepts both "3" and 3.
+type legacyWorkoutInput struct {
+	DayID json.Number `json:"day_id"`
+	service.WorkoutInput
 }
-type Week struct {
-    ID        int    `json:"id"`
-    StartDate string `json:"start_date"`
+
+type legacyDeleteInput struct {
+	ID json.Number `json:"id"`
 }
 
-type Day struct {
-    ID       int    `json:"id"`
-    WeekID   int    `json:"week_id"`
-    DayDate  string `json:"day_date"`
+type legacyLiftInput struct {
+	WorkoutID int `json:"workout_id"`
+	service.LiftInput
 }
 
-type Meal struct {
-    ID       int    `json:"id"`
-    DayID    int    `json:"day_id"`
-    Name     string `json:"name"`
-    Calories int    `json:"calories"`
+type legacyMealInput struct {
+	DayID int `json:"day_id"`
+	service.MealInput
 }
 
 func addWeekHandler(w http.ResponseWriter, r *http.Request) {
-    incrementVisit("add-week")
-    if r.Method != http.MethodPost {
-        http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-        return
-    }
-
-    var week Week
-    if err := json.NewDecoder(r.Body).Decode(&week); err != nil {
-        http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-        return
-    }
-
-    _, err := db.Exec("INSERT INTO weeks (start_date) VALUES ($1)", week.StartDate)
-    if err != nil {
-        http.Error(w, "Error adding week", http.StatusInternalServerError)
-        return
-    }
-
-    w.WriteHeader(http.StatusOK)
+	var week service.WeekInput
+	if !decodeJSON(w, r, &week) {
+		return
+	}
+
+	if _, err := svc.CreateWeek(r.Context(), week); err != nil {
+		writeErr(w, r, "adding week", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
 func addDayHandler(w http.ResponseWriter, r *http.Request) {
-    incrementVisit("add-day")
-    if r.Method != http.MethodPost {
-        http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-        return
-    }
-
-    weekID := r.FormValue("week_id")
-    dayDate := r.FormValue("day_date")
-
-    if weekID == "" || dayDate == "" {
-        http.Error(w, "Missing week_id or day_date", http.StatusBadRequest)
-        return
-    }
-
-    // Insert the new day and return the inserted ID
-    var dayID int
-    err := db.QueryRow("INSERT INTO days (week_id, day_date) VALUES ($1, $2) RETURNING id", weekID, dayDate).Scan(&dayID)
-    if err != nil {
-        log.Printf("Error inserting day: %v", err)
-        http.Error(w, "Error inserting day", http.StatusInternalServerError)
-        return
-    }
-
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]interface{}{
-        "status": "success",
-        "message": "Day added successfully",
-        "dayID": dayID,
-    })
-}
+	var v service.Validator
+	weekID := v.ID("week_id", r.FormValue("week_id"))
+	dayDate := r.FormValue("day_date")
+	v.Date("day_date", dayDate)
+	if err := v.Err(); err != nil {
+		writeErr(w, r, "", err)
+		return
+	}
 
-func addMealHandler(w http.ResponseWriter, r *http.Request) {
-    incrementVisit("add-meal")
-    if r.Method != http.MethodPost {
-        http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-        return
-    }
-
-    var meal Meal
-    if err := json.NewDecoder(r.Body).Decode(&meal); err != nil {
-        log.Printf("Invalid JSON format: %v", err)
-        http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-        return
-    }
-
-    _, err := db.Exec("INSERT INTO meals (day_id, name, calories) VALUES ($1, $2, $3)", meal.DayID, meal.Name, meal.Calories)
-    if err != nil {
-        log.Printf("Error inserting meal: %v", err)
-        http.Error(w, "Error adding meal", http.StatusInternalServerError)
-        return
-    }
-
-    w.WriteHeader(http.StatusOK)
-    json.NewEncoder(w).Encode(map[string]string{
-        "status":  "success",
-        "message": "Meal added successfully",
-    })
+	day, err := svc.CreateDay(r.Context(), weekID, service.DayInput{DayDate: dayDate})
+	if err != nil {
+		writeErr(w, r, "inserting day", service.RequireParent("week_id", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": "Day added successfully",
+		"dayID":   day.ID,
+	})
 }
 
-func addWorkoutHandler(w http.ResponseWriter, r *http.Request) {
-    incrementVisit("add-workout")
-    if r.Method != http.MethodPost {
-        http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-        return
-    }
-
-    // Parse JSON data
-    var workout struct {
-        DayID    string `json:"day_id"`
-        Name     string `json:"name"`
-        Duration int    `json:"duration"`
-    }
-
-    err := json.NewDecoder(r.Body).Decode(&workout)
-    if err != nil {
-        log.Printf("Invalid JSON data: %v", err)
-        http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-        return
-    }
-
-    if workout.DayID == "" || workout.Name == "" || workout.Duration <= 0 {
-        http.Error(w, "Missing or invalid fields", http.StatusBadRequest)
-        return
-    }
-
-    // Insert workout into the database
-    var workoutID int
-    err = db.QueryRow("INSERT INTO workouts (day_id, name, duration) VALUES ($1, $2, $3) RETURNING id",
-        workout.DayID, workout.Name, workout.Duration).Scan(&workoutID)
-    if err != nil {
-        log.Printf("Error inserting workout: %v", err)
-        http.Error(w, "Error adding workout", http.StatusInternalServerError)
-        return
-    }
-
-    // Respond with the new workout ID
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]interface{}{
-        "status": "success",
-        "id":     workoutID,
-    })
+func (in *legacyMealInput) Validate() error {
+	var v service.Validator
+	v.Positive("day_id", float64(in.DayID))
+	in.Check(&v)
+	return v.Err()
 }
 
+func addMealHandler(w http.ResponseWriter, r *http.Request) {
+	var meal legacyMealInput
+	if !decodeAndValidate(w, r, &meal) {
+		return
+	}
 
-func listWorkoutsHandler(w http.ResponseWriter, r *http.Request) {
-	incrementVisit("list-workouts")
-	dayIDStr := r.URL.Query().Get("day_id")
-	if dayIDStr == "" {
-		http.Error(w, "day_id parameter is required", http.StatusBadRequest)
+	if _, err := svc.CreateMeal(r.Context(), meal.DayID, meal.MealInput); err != nil {
+		writeErr(w, r, "adding meal", service.RequireParent("day_id", err))
 		return
 	}
 
-	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Meal added successfully",
+	})
+}
+
+func (in *legacyWorkoutInput) Validate() error {
+	var v service.Validator
+	v.ID("day_id", in.DayID.String())
+	in.Check(&v)
+	return v.Err()
+}
 
-	dayID, err := strconv.Atoi(dayIDStr)
-	fmt.Printf("got %v", dayID)
+func addWorkoutHandler(w http.ResponseWriter, r *http.Request) {
+	var workout legacyWorkoutInput
+	if !decodeAndValidate(w, r, &workout) {
+		return
+	}
 
+	dayID, _ := strconv.Atoi(workout.DayID.String())
+	created, err := svc.CreateWorkout(r.Context(), dayID, workout.WorkoutInput)
 	if err != nil {
-		http.Error(w, "Invalid day_id parameter", http.StatusBadRequest)
+		writeErr(w, r, "adding workout", service.RequireParent("day_id", err))
 		return
 	}
-	rows, err := db.Query("SELECT id, name, duration FROM workouts WHERE day_id = $1", dayID)
-	if err != nil {
-		log.Printf("Error executing query: %v", err)
-		http.Error(w, "Error fetching workouts", http.StatusInternalServerError)
+
+	// Respond with the new workout ID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"id":     created.ID,
+	})
+}
+
+func listWorkoutsHandler(w http.ResponseWriter, r *http.Request) {
+	var v service.Validator
+	dayID := v.ID("day_id", r.URL.Query().Get("day_id"))
+	if err := v.Err(); err != nil {
+		writeErr(w, r, "", err)
 		return
 	}
 
-	defer rows.Close()
+	p, ok := parseList(w, r, service.WorkoutList)
+	if !ok {
+		return
+	}
 
-	var workouts []Workout
-	for rows.Next() {
-		var workout Workout
-		if err := rows.Scan(&workout.ID, &workout.Name, &workout.Duration); err != nil {
-			http.Error(w, "Error scanning workouts", http.StatusInternalServerError)
-			return
-		}
-		workouts = append(workouts, workout)
+	// An unknown day has always listed as empty here.
+	workouts, err := svc.ListWorkouts(r.Context(), dayID, p)
+	if errors.Is(err, service.ErrNotFound) {
+		workouts, err = service.Page[service.Workout]{Items: []service.Workout{}}, nil
+	}
+	if err != nil {
+		writeErr(w, r, "fetching workouts", err)
+		return
 	}
 
+	setPageHeaders(w, r, workouts)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(workouts)
+	json.NewEncoder(w).Encode(workouts.Items)
 }
 
-func addLiftHandler(w http.ResponseWriter, r *http.Request) {
-    incrementVisit("add-lift")
-    if r.Method != http.MethodPost {
-        http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-        return
-    }
-
-    var lift struct {
-        WorkoutID int     `json:"workout_id"`
-        Name      string  `json:"name"`
-        Weight    float64 `json:"weight"`
-        Reps      int     `json:"reps"`
-        LiftOrder int     `json:"lift_order"`
-        RestTime  int     `json:"rest_time"`
-        BPM       int     `json:"bpm"`
-    }
-
-    // Decode JSON from the request body
-    if err := json.NewDecoder(r.Body).Decode(&lift); err != nil {
-        log.Printf("Invalid JSON format: %v", err)
-        http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-        return
-    }
-
-    // Validate the incoming data
-    if lift.WorkoutID <= 0 || lift.Name == "" || lift.Weight <= 0 || lift.Reps <= 0 || lift.LiftOrder <= 0 {
-        http.Error(w, "Missing or invalid fields", http.StatusBadRequest)
-        return
-    }
-
-    // Insert the lift into the database
-    _, err := db.Exec(
-        "INSERT INTO lifts (workout_id, name, weight, reps, lift_order, rest_time, bpm) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-        lift.WorkoutID, lift.Name, lift.Weight, lift.Reps, lift.LiftOrder, lift.RestTime, lift.BPM,
-    )
-    if err != nil {
-        log.Printf("Error inserting lift: %v", err)
-        http.Error(w, "Error adding lift", http.StatusInternalServerError)
-        return
-    }
-
-    // Return a JSON response
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{
-        "status":  "success",
-        "message": "Lift added successfully",
-    })
+func (in *legacyLiftInput) Validate() error {
+	var v service.Validator
+	v.Positive("workout_id", float64(in.WorkoutID))
+	in.Check(&v)
+	// /add-lift has always required a weight; only the v1 API and GraphQL
+	// take bodyweight lifts. A negative weight is reported by Check.
+	if in.Weight == 0 {
+		v.Positive("weight", in.Weight)
+	}
+	return v.Err()
 }
 
+func addLiftHandler(w http.ResponseWriter, r *http.Request) {
+	var lift legacyLiftInput
+	if !decodeAndValidate(w, r, &lift) {
+		return
+	}
 
+	if _, err := svc.CreateLift(r.Context(), lift.WorkoutID, lift.LiftInput); err != nil {
+		writeErr(w, r, "adding lift", service.RequireParent("workout_id", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Lift added successfully",
+	})
+}
 
 func listLiftsHandler(w http.ResponseWriter, r *http.Request) {
-	incrementVisit("list-lifts")
-	workoutID := r.URL.Query().Get("workout_id")
-	if workoutID == "" {
-		http.Error(w, "Missing workout_id", http.StatusBadRequest)
+	var v service.Validator
+	workoutID := v.ID("workout_id", r.URL.Query().Get("workout_id"))
+	if err := v.Err(); err != nil {
+		writeErr(w, r, "", err)
 		return
 	}
 
-	rows, err := db.Query(`
-        SELECT id, name, weight, reps, lift_order, rest_time, bpm
-        FROM lifts WHERE workout_id = $1 ORDER BY lift_order`, workoutID)
-	if err != nil {
-		http.Error(w, "Error fetching lifts", http.StatusInternalServerError)
+	p, ok := parseList(w, r, service.LiftList)
+	if !ok {
 		return
 	}
-	defer rows.Close()
-
-	var lifts []Lift
-	for rows.Next() {
-		var lift Lift
-		if err := rows.Scan(&lift.WorkoutID, &lift.Name, &lift.Weight, &lift.Reps, &lift.LiftOrder, &lift.RestTime, &lift.BPM); err != nil {
-			http.Error(w, "Error scanning lifts", http.StatusInternalServerError)
-			return
-		}
-		lifts = append(lifts, lift)
+
+	// An unknown workout has always listed as empty here.
+	lifts, err := svc.ListLifts(r.Context(), workoutID, p)
+	if errors.Is(err, service.ErrNotFound) {
+		lifts, err = service.Page[service.Lift]{Items: []service.Lift{}}, nil
+	}
+	if err != nil {
+		writeErr(w, r, "fetching lifts", err)
+		return
 	}
 
+	setPageHeaders(w, r, lifts)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(lifts)
+	json.NewEncoder(w).Encode(lifts.Items)
+}
+
+func (in *legacyDeleteInput) Validate() error {
+	var v service.Validator
+	v.ID("id", in.ID.String())
+	return v.Err()
 }
 
 func deleteWorkoutHandler(w http.ResponseWriter, r *http.Request) {
-    incrementVisit("delete-workout")
-    if r.Method != http.MethodPost {
-        http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-        return
-    }
-
-    var req struct {
-        ID string `json:"id"`
-    }
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-        return
-    }
-
-    _, err := db.Exec("DELETE FROM workouts WHERE id = $1", req.ID)
-    if err != nil {
-        http.Error(w, "Error deleting workout", http.StatusInternalServerError)
-        return
-    }
-
-    w.WriteHeader(http.StatusOK)
+	var req legacyDeleteInput
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	// Deleting a workout that is already gone is not an error for the
+	// legacy endpoint.
+	id, _ := strconv.Atoi(req.ID.String())
+	if err := svc.DeleteWorkout(r.Context(), id); err != nil && !errors.Is(err, service.ErrNotFound) {
+		writeErr(w, r, "deleting workout", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
-func incrementVisit(endpoint string) {
-    query := `
-    UPDATE endpoint_visits
-    SET visit_count = visit_count + 1
-    WHERE endpoint = $1;
-    `
-    _, err := db.Exec(query, endpoint)
-    if err != nil {
-        log.Printf("Error incrementing visit count for %s: %v", endpoint, err)
-    } else {
-        log.Printf("Visit count incremented for %s", endpoint)
-    }
+// setPageHeaders exposes the paging state of a legacy list endpoint, whose
+// body stays a bare JSON array, as X-Next-Cursor, X-Total-Count and a
+// rel="next" Link header.
+func setPageHeaders[T any](w http.ResponseWriter, r *http.Request, pg service.Page[T]) {
+	if pg.Total != nil {
+		w.Header().Set("X-Total-Count", strconv.Itoa(*pg.Total))
+	}
+	if pg.NextCursor != nil {
+		w.Header().Set("X-Next-Cursor", *pg.NextCursor)
+		q := r.URL.Query()
+		q.Set("cursor", *pg.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, q.Encode()))
+	}
 }
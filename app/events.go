@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"lab8-go/service"
+)
+
+const (
+	// eventBacklog is how many recent events are kept for streams that
+	// reconnect with a Last-Event-ID.
+	eventBacklog = 256
+	// eventBuffer is how many events a stream may fall behind before it
+	// is closed; the browser reconnects and catches up from the backlog.
+	eventBuffer = 32
+	// eventKeepAlive is how often an idle stream gets a comment, so
+	// proxies do not close it.
+	eventKeepAlive = 25 * time.Second
+)
+
+// liveEvents fans the service's events out to the open event streams.
+var liveEvents = newEventHub()
+
+// addEventStream registers GET /events, which streams changes to one week
+// or day as server-sent events.
+func addEventStream() {
+	svc.Subscribe(liveEvents.publish)
+	http.HandleFunc("GET /events", eventsHandler)
+}
+
+// eventScope selects the events in one week or on one day.
+type eventScope struct {
+	weekID, dayID int
+}
+
+func (s eventScope) matches(e service.Event) bool {
+	return (s.weekID == 0 || e.WeekID == s.weekID) && (s.dayID == 0 || e.DayID == s.dayID)
+}
+
+// streamEvent is an event numbered in the order it was published. The
+// number is sent as the SSE id, so a reconnecting browser says in
+// Last-Event-ID where it left off.
+type streamEvent struct {
+	seq int64
+	service.Event
+}
+
+type eventSub struct {
+	scope eventScope
+	ch    chan streamEvent
+}
+
+type eventHub struct {
+	mu     sync.Mutex
+	seq    int64
+	recent []streamEvent // the last eventBacklog events, oldest first
+	subs   map[*eventSub]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: map[*eventSub]bool{}}
+}
+
+// publish numbers e and sends it to every stream in its scope. It never
+// blocks: a stream whose buffer is full is closed instead.
+func (h *eventHub) publish(e service.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	se := streamEvent{h.seq, e}
+	h.recent = append(h.recent, se)
+	if len(h.recent) > eventBacklog {
+		h.recent = append(h.recent[:0], h.recent[1:]...)
+	}
+	for sub := range h.subs {
+		if !sub.scope.matches(e) {
+			continue
+		}
+		select {
+		case sub.ch <- se:
+		default:
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// subscribe opens a stream of the events in scope. It also returns the
+// events in scope published after lastSeq, or complete false if some of
+// those are no longer kept (or lastSeq is from before a restart), in
+// which case the client has to reload to catch up.
+func (h *eventHub) subscribe(scope eventScope, lastSeq int64) (sub *eventSub, missed []streamEvent, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub = &eventSub{scope: scope, ch: make(chan streamEvent, eventBuffer)}
+	h.subs[sub] = true
+	if lastSeq == 0 {
+		return sub, nil, true
+	}
+	if lastSeq > h.seq || lastSeq < h.seq-int64(len(h.recent)) {
+		return sub, nil, false
+	}
+	for _, se := range h.recent {
+		if se.seq > lastSeq && scope.matches(se.Event) {
+			missed = append(missed, se)
+		}
+	}
+	return sub, missed, true
+}
+
+func (h *eventHub) unsubscribe(sub *eventSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[sub] {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (h *eventHub) lastSeq() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.seq
+}
+
+// parseEventScope reads the week_id or day_id a stream is scoped to;
+// exactly one is required.
+func parseEventScope(q url.Values) (eventScope, error) {
+	var v service.Validator
+	var scope eventScope
+	switch {
+	case q.Has("week_id") && q.Has("day_id"):
+		return scope, &service.ParamError{Param: "day_id", Message: "cannot be combined with week_id"}
+	case q.Has("week_id"):
+		scope.weekID = v.ID("week_id", q.Get("week_id"))
+	case q.Has("day_id"):
+		scope.dayID = v.ID("day_id", q.Get("day_id"))
+	default:
+		return scope, &service.ParamError{Param: "week_id", Message: "week_id or day_id is required"}
+	}
+	return scope, v.Err()
+}
+
+// eventsHandler streams the changes to a week (?week_id=) or a day
+// (?day_id=) as server-sent events, until the client goes away or the
+// server shuts down. Each event is named by its type, such as
+// workout.created, and its data is the JSON of service.Event. A client
+// that reconnects too late to catch up gets a reset event instead.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	scope, err := parseEventScope(r.URL.Query())
+	if err == nil && scope.weekID != 0 {
+		_, err = svc.GetWeek(r.Context(), scope.weekID)
+	} else if err == nil {
+		_, err = svc.GetDay(r.Context(), scope.dayID)
+	}
+	if err != nil {
+		writeErr(w, r, "opening event stream", err)
+		return
+	}
+	streamEvents(w, r, scope)
+}
+
+func streamEvents(w http.ResponseWriter, r *http.Request, scope eventScope) {
+	lastSeq, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	sub, missed, complete := liveEvents.subscribe(scope, lastSeq)
+	defer liveEvents.unsubscribe(sub)
+
+	// The stream outlives http.write_timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if !complete {
+		fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", liveEvents.lastSeq())
+	}
+	for _, se := range missed {
+		writeStreamEvent(w, se)
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-draining:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case se, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			writeStreamEvent(w, se)
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, se streamEvent) {
+	data, _ := json.Marshal(se.Event)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", se.seq, se.Type, data)
+}
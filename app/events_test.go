@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lab8-go/service"
+)
+
+func TestEventHubScopes(t *testing.T) {
+	h := newEventHub()
+	week, _, _ := h.subscribe(eventScope{weekID: 1}, 0)
+	day, _, _ := h.subscribe(eventScope{dayID: 5}, 0)
+
+	h.publish(service.Event{Type: service.DayCreated, ID: 5, WeekID: 1, DayID: 5})
+	h.publish(service.Event{Type: service.WorkoutCreated, ID: 9, WeekID: 1, DayID: 6})
+	h.publish(service.Event{Type: service.MealCreated, ID: 2, WeekID: 2, DayID: 7})
+
+	if got := len(week.ch); got != 2 {
+		t.Errorf("week stream got %d events, want 2", got)
+	}
+	if got := len(day.ch); got != 1 || (<-day.ch).ID != 5 {
+		t.Errorf("day stream got %d events, want the day.created of day 5", got)
+	}
+
+	h.unsubscribe(day)
+	for i := 0; i < eventBuffer+1; i++ {
+		h.publish(service.Event{Type: service.WorkoutCreated, WeekID: 1})
+	}
+	if len(h.subs) != 0 {
+		t.Errorf("a stream that fell behind is still subscribed")
+	}
+}
+
+func TestEventHubCatchUp(t *testing.T) {
+	h := newEventHub()
+	for i := 1; i <= eventBacklog+10; i++ {
+		h.publish(service.Event{Type: service.WorkoutCreated, ID: i, WeekID: 1, DayID: i % 2})
+	}
+	last := int64(eventBacklog + 10)
+
+	_, missed, complete := h.subscribe(eventScope{dayID: 1}, last-4)
+	if !complete || len(missed) != 2 || missed[0].seq != last-3 || missed[1].seq != last-1 {
+		t.Errorf("recent: missed %v, complete %v; want events %d and %d", missed, complete, last-3, last-1)
+	}
+	if _, _, complete := h.subscribe(eventScope{weekID: 1}, 5); complete {
+		t.Error("an id older than the backlog is reported as complete")
+	}
+	if _, _, complete := h.subscribe(eventScope{weekID: 1}, last+1); complete {
+		t.Error("an id from a previous run is reported as complete")
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	liveEvents = newEventHub()
+	liveEvents.publish(service.Event{Type: service.DayCreated, ID: 4, WeekID: 2, DayID: 4})
+	liveEvents.publish(service.Event{Type: service.WorkoutDeleted, ID: 8, WeekID: 2, DayID: 4})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/events?week_id=2", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "1")
+	rec := httptest.NewRecorder()
+	streamEvents(rec, req, eventScope{weekID: 2})
+
+	want := "id: 2\nevent: workout.deleted\ndata: {\"type\":\"workout.deleted\",\"id\":8,\"week_id\":2,\"day_id\":4}\n\n"
+	if rec.Header().Get("Content-Type") != "text/event-stream" || !strings.HasSuffix(rec.Body.String(), want) {
+		t.Errorf("stream %q: body %q, want it to end with %q", rec.Header().Get("Content-Type"), rec.Body, want)
+	}
+	if len(liveEvents.subs) != 0 {
+		t.Error("the stream is still subscribed after the client left")
+	}
+}
+
+func TestParseEventScope(t *testing.T) {
+	for query, ok := range map[string]bool{
+		"week_id=3":          true,
+		"day_id=4":           true,
+		"":                   false,
+		"week_id=x":          false,
+		"week_id=3&day_id=4": false,
+	} {
+		req := httptest.NewRequest("GET", "/events?"+query, nil)
+		if _, err := parseEventScope(req.URL.Query()); (err == nil) != ok {
+			t.Errorf("%q: err %v", query, err)
+		}
+	}
+}
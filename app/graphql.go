@@ -0,0 +1,714 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+
+	"lab8-go/service"
+)
+
+// graphqlSchema mirrors the week -> day -> workout/meal -> lift hierarchy.
+// Dates are YYYY-MM-DD strings, as in the JSON API. The top-level lists
+// are paged like the JSON API's: first is the page size and after the
+// nextCursor of the previous page. Nested lists return their first rows
+// only; a week has at most seven days.
+const graphqlSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+type Query {
+	weeks(from: String, to: String, first: Int, after: String): WeekPage!
+	week(id: ID!): Week
+	days(from: String, to: String, first: Int, after: String): DayPage!
+	day(id: ID!): Day
+	workout(id: ID!): Workout
+}
+
+type Mutation {
+	addWeek(startDate: String!): Week!
+	deleteWeek(id: ID!): Boolean!
+	addDay(weekId: ID!, dayDate: String!): Day!
+	deleteDay(id: ID!): Boolean!
+	addWorkout(dayId: ID!, name: String!, duration: Int!): Workout!
+	deleteWorkout(id: ID!): Boolean!
+	addLift(workoutId: ID!, input: LiftInput!): Lift!
+	deleteLift(id: ID!): Boolean!
+	addMeal(dayId: ID!, name: String!, calories: Int): Meal!
+	deleteMeal(id: ID!): Boolean!
+}
+
+input LiftInput {
+	name: String!
+	weight: Float!
+	reps: Int!
+	liftOrder: Int!
+	restTime: Int!
+	bpm: Int
+}
+
+type WeekPage {
+	items: [Week!]!
+	nextCursor: String
+}
+
+type DayPage {
+	items: [Day!]!
+	nextCursor: String
+}
+
+type Week {
+	id: ID!
+	startDate: String!
+	days: [Day!]!
+}
+
+type Day {
+	id: ID!
+	dayDate: String!
+	week: Week!
+	workouts(first: Int): [Workout!]!
+	meals(first: Int): [Meal!]!
+}
+
+type Workout {
+	id: ID!
+	name: String!
+	duration: Int!
+	time: String!
+	day: Day!
+	lifts(first: Int): [Lift!]!
+}
+
+type Lift {
+	id: ID!
+	name: String!
+	weight: Float!
+	reps: Int!
+	liftOrder: Int!
+	restTime: Int!
+	bpm: Int!
+	workout: Workout!
+}
+
+type Meal {
+	id: ID!
+	name: String!
+	calories: Int!
+	day: Day!
+}
+`
+
+var schema = graphql.MustParseSchema(graphqlSchema, &graphqlResolver{}, graphql.MaxDepth(12))
+
+// addGraphQL serves the GraphQL endpoint.
+func addGraphQL() {
+	handleJSON("POST /graphql", graphqlHandler)
+}
+
+// graphqlRequest is the standard GraphQL-over-HTTP request body.
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// graphqlResponse documents the response body for the OpenAPI spec; the
+// handler encodes graphql.Response directly.
+type graphqlResponse struct {
+	Data   map[string]any   `json:"data,omitempty"`
+	Errors []map[string]any `json:"errors,omitempty"`
+}
+
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Query == "" {
+		writeErr(w, r, "", &service.ValidationError{Fields: []service.FieldError{{Field: "query", Code: "required", Message: "is required"}}})
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), loadersKey{}, newLoaders(r.Context()))
+	writeJSON(w, http.StatusOK, schema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+}
+
+// loaders batch the nested lookups of one GraphQL request. Whenever a list
+// of parents is resolved its ids are primed on the child loaders, so the
+// first child lookup fetches the children of every sibling in one query
+// instead of one query per parent.
+type loaders struct {
+	weeks          *batchLoader[service.Week]
+	days           *batchLoader[service.Day]
+	workouts       *batchLoader[service.Workout]
+	daysByWeek     *batchLoader[[]service.Day]
+	workoutsByDay  *batchLoader[[]service.Workout]
+	mealsByDay     *batchLoader[[]service.Meal]
+	liftsByWorkout *batchLoader[[]service.Lift]
+}
+
+type loadersKey struct{}
+
+// newLoaders returns the loaders for one request; their queries run with
+// ctx.
+func newLoaders(ctx context.Context) *loaders {
+	return &loaders{
+		weeks:          newBatchLoader(withContext(ctx, svc.WeeksByIDs)),
+		days:           newBatchLoader(withContext(ctx, svc.DaysByIDs)),
+		workouts:       newBatchLoader(withContext(ctx, svc.WorkoutsByIDs)),
+		daysByWeek:     newBatchLoader(withContext(ctx, svc.DaysByWeekIDs)),
+		workoutsByDay:  newBatchLoader(withContext(ctx, svc.WorkoutsByDayIDs)),
+		mealsByDay:     newBatchLoader(withContext(ctx, svc.MealsByDayIDs)),
+		liftsByWorkout: newBatchLoader(withContext(ctx, svc.LiftsByWorkoutIDs)),
+	}
+}
+
+func withContext[V any](ctx context.Context, fetch func(context.Context, []int) (map[int]V, error)) func([]int) (map[int]V, error) {
+	return func(ids []int) (map[int]V, error) { return fetch(ctx, ids) }
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	if l, ok := ctx.Value(loadersKey{}).(*loaders); ok {
+		return l
+	}
+	return newLoaders(ctx)
+}
+
+// batchLoader caches values by id and fetches every primed id that has
+// not been loaded yet in a single call.
+type batchLoader[V any] struct {
+	mu      sync.Mutex
+	fetch   func(ids []int) (map[int]V, error)
+	pending map[int]bool
+	loaded  map[int]V
+}
+
+func newBatchLoader[V any](fetch func(ids []int) (map[int]V, error)) *batchLoader[V] {
+	return &batchLoader[V]{fetch: fetch, pending: map[int]bool{}, loaded: map[int]V{}}
+}
+
+// prime queues ids to be fetched together with the next load.
+func (l *batchLoader[V]) prime(ids ...int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := l.loaded[id]; !ok {
+			l.pending[id] = true
+		}
+	}
+}
+
+// load returns the value for id. A missing row loads as the zero value,
+// which is an empty list for the child loaders.
+func (l *batchLoader[V]) load(id int) (V, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if v, ok := l.loaded[id]; ok {
+		return v, true, nil
+	}
+
+	l.pending[id] = true
+	ids := make([]int, 0, len(l.pending))
+	for pending := range l.pending {
+		ids = append(ids, pending)
+	}
+	sort.Ints(ids)
+	l.pending = map[int]bool{}
+
+	values, err := l.fetch(ids)
+	if err != nil {
+		var zero V
+		return zero, false, err
+	}
+	for _, pending := range ids {
+		l.loaded[pending] = values[pending]
+	}
+	v, ok := values[id]
+	return v, ok, nil
+}
+
+// loadOne loads a single row, mapping a missing row to ErrNotFound.
+func loadOne[V any](l *batchLoader[V], id int) (V, error) {
+	v, ok, err := l.load(id)
+	if err == nil && !ok {
+		err = service.ErrNotFound
+	}
+	return v, err
+}
+
+func parseID(id graphql.ID) (int, error) {
+	n, err := strconv.Atoi(string(id))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid id %q", id)
+	}
+	return n, nil
+}
+
+func formatID(id int) graphql.ID {
+	return graphql.ID(strconv.Itoa(id))
+}
+
+// optional returns the value of a nullable string argument.
+func optional(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+type graphqlResolver struct{}
+
+// pageArgs are the filters and paging of a top-level list.
+type pageArgs struct {
+	From  *string
+	To    *string
+	First *int32
+	After *string
+}
+
+// pageParams parses args as the JSON API parses a list's query string,
+// reporting errors under the GraphQL argument names.
+func pageParams[T any](spec service.ListSpec[T], args pageArgs) (service.ListParams, error) {
+	q := url.Values{}
+	q.Set("from", optional(args.From))
+	q.Set("to", optional(args.To))
+	q.Set("cursor", optional(args.After))
+	if args.First != nil {
+		q.Set("limit", strconv.Itoa(int(*args.First)))
+	}
+	p, err := spec.Parse(q)
+	var perr *service.ParamError
+	if errors.As(err, &perr) {
+		switch perr.Param {
+		case "limit":
+			perr.Param = "first"
+		case "cursor":
+			perr.Param = "after"
+		}
+	}
+	return p, err
+}
+
+// pageResolver is a page of a top-level list.
+type pageResolver[R any] struct {
+	items []R
+	next  *string
+}
+
+func (r *pageResolver[R]) Items() []R          { return r.items }
+func (r *pageResolver[R]) NextCursor() *string { return r.next }
+
+func (*graphqlResolver) Weeks(ctx context.Context, args pageArgs) (*pageResolver[*weekResolver], error) {
+	p, err := pageParams(service.WeekList, args)
+	if err != nil {
+		return nil, err
+	}
+	weeks, err := svc.ListWeeks(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return &pageResolver[*weekResolver]{newWeekResolvers(loadersFrom(ctx), weeks.Items), weeks.NextCursor}, nil
+}
+
+func (*graphqlResolver) Week(ctx context.Context, args struct{ ID graphql.ID }) (*weekResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	l := loadersFrom(ctx)
+	week, err := loadOne(l.weeks, id)
+	if errors.Is(err, service.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newWeekResolvers(l, []service.Week{week})[0], nil
+}
+
+func (*graphqlResolver) Days(ctx context.Context, args pageArgs) (*pageResolver[*dayResolver], error) {
+	p, err := pageParams(service.DayList, args)
+	if err != nil {
+		return nil, err
+	}
+	days, err := svc.ListAllDays(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return &pageResolver[*dayResolver]{newDayResolvers(loadersFrom(ctx), days.Items), days.NextCursor}, nil
+}
+
+// firstArgs limit a nested list.
+type firstArgs struct {
+	First *int32
+}
+
+// firstOf returns the first rows of a nested list, by default as many as
+// a page of the JSON API. The loaders never fetch more than
+// service.MaxPageLimit per parent.
+func firstOf[T any](items []T, args firstArgs) ([]T, error) {
+	n := service.DefaultPageLimit
+	if args.First != nil {
+		n = int(*args.First)
+	}
+	if n < 1 || n > service.MaxPageLimit {
+		return nil, &service.ParamError{Param: "first", Message: fmt.Sprintf("must be an integer between 1 and %d", service.MaxPageLimit)}
+	}
+	return items[:min(n, len(items))], nil
+}
+
+func (*graphqlResolver) Day(ctx context.Context, args struct{ ID graphql.ID }) (*dayResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	l := loadersFrom(ctx)
+	day, err := loadOne(l.days, id)
+	if errors.Is(err, service.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newDayResolvers(l, []service.Day{day})[0], nil
+}
+
+func (*graphqlResolver) Workout(ctx context.Context, args struct{ ID graphql.ID }) (*workoutResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	l := loadersFrom(ctx)
+	workout, err := loadOne(l.workouts, id)
+	if errors.Is(err, service.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newWorkoutResolvers(l, []service.Workout{workout})[0], nil
+}
+
+// Mutations. These apply the same checks as the /api/v1 handlers.
+
+func (*graphqlResolver) AddWeek(ctx context.Context, args struct{ StartDate string }) (*weekResolver, error) {
+	week, err := svc.CreateWeek(ctx, service.WeekInput{StartDate: args.StartDate})
+	if err != nil {
+		return nil, err
+	}
+	return newWeekResolvers(loadersFrom(ctx), []service.Week{week})[0], nil
+}
+
+func (*graphqlResolver) AddDay(ctx context.Context, args struct {
+	WeekID  graphql.ID
+	DayDate string
+}) (*dayResolver, error) {
+	weekID, err := parseID(args.WeekID)
+	if err != nil {
+		return nil, err
+	}
+	day, err := svc.CreateDay(ctx, weekID, service.DayInput{DayDate: args.DayDate})
+	if err != nil {
+		return nil, service.RequireParent("week_id", err)
+	}
+	return newDayResolvers(loadersFrom(ctx), []service.Day{day})[0], nil
+}
+
+func (*graphqlResolver) AddWorkout(ctx context.Context, args struct {
+	DayID    graphql.ID
+	Name     string
+	Duration int32
+}) (*workoutResolver, error) {
+	dayID, err := parseID(args.DayID)
+	if err != nil {
+		return nil, err
+	}
+	workout, err := svc.CreateWorkout(ctx, dayID, service.WorkoutInput{Name: args.Name, Duration: int(args.Duration)})
+	if err != nil {
+		return nil, service.RequireParent("day_id", err)
+	}
+	return newWorkoutResolvers(loadersFrom(ctx), []service.Workout{workout})[0], nil
+}
+
+type liftInputArgs struct {
+	Name      string
+	Weight    float64
+	Reps      int32
+	LiftOrder int32
+	RestTime  int32
+	BPM       *int32
+}
+
+func (*graphqlResolver) AddLift(ctx context.Context, args struct {
+	WorkoutID graphql.ID
+	Input     liftInputArgs
+}) (*liftResolver, error) {
+	workoutID, err := parseID(args.WorkoutID)
+	if err != nil {
+		return nil, err
+	}
+	in := service.LiftInput{
+		Name:      args.Input.Name,
+		Weight:    args.Input.Weight,
+		Reps:      int(args.Input.Reps),
+		LiftOrder: int(args.Input.LiftOrder),
+		RestTime:  int(args.Input.RestTime),
+	}
+	if args.Input.BPM != nil {
+		in.BPM = int(*args.Input.BPM)
+	}
+	created, err := svc.CreateLift(ctx, workoutID, in)
+	if err != nil {
+		return nil, service.RequireParent("workout_id", err)
+	}
+	return newLiftResolvers(loadersFrom(ctx), []service.Lift{created})[0], nil
+}
+
+func (*graphqlResolver) AddMeal(ctx context.Context, args struct {
+	DayID    graphql.ID
+	Name     string
+	Calories *int32
+}) (*mealResolver, error) {
+	dayID, err := parseID(args.DayID)
+	if err != nil {
+		return nil, err
+	}
+	in := service.MealInput{Name: args.Name}
+	if args.Calories != nil {
+		in.Calories = int(*args.Calories)
+	}
+	created, err := svc.CreateMeal(ctx, dayID, in)
+	if err != nil {
+		return nil, service.RequireParent("day_id", err)
+	}
+	return newMealResolvers(loadersFrom(ctx), []service.Meal{created})[0], nil
+}
+
+func (*graphqlResolver) DeleteWeek(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	return graphqlDelete(ctx, args.ID, svc.DeleteWeek)
+}
+
+func (*graphqlResolver) DeleteDay(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	return graphqlDelete(ctx, args.ID, svc.DeleteDay)
+}
+
+func (*graphqlResolver) DeleteWorkout(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	return graphqlDelete(ctx, args.ID, svc.DeleteWorkout)
+}
+
+func (*graphqlResolver) DeleteLift(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	return graphqlDelete(ctx, args.ID, svc.DeleteLift)
+}
+
+func (*graphqlResolver) DeleteMeal(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	return graphqlDelete(ctx, args.ID, svc.DeleteMeal)
+}
+
+// graphqlDelete reports whether a row was deleted; deleting a missing row
+// answers false rather than an error.
+func graphqlDelete(ctx context.Context, id graphql.ID, del func(context.Context, int) error) (bool, error) {
+	n, err := parseID(id)
+	if err != nil {
+		return false, err
+	}
+	err = del(ctx, n)
+	if errors.Is(err, service.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type weekResolver struct {
+	l    *loaders
+	week service.Week
+}
+
+func newWeekResolvers(l *loaders, weeks []service.Week) []*weekResolver {
+	resolvers := make([]*weekResolver, len(weeks))
+	ids := make([]int, len(weeks))
+	for i, week := range weeks {
+		resolvers[i] = &weekResolver{l: l, week: week}
+		ids[i] = week.ID
+	}
+	l.daysByWeek.prime(ids...)
+	return resolvers
+}
+
+func (r *weekResolver) ID() graphql.ID    { return formatID(r.week.ID) }
+func (r *weekResolver) StartDate() string { return r.week.StartDate }
+
+func (r *weekResolver) Days() ([]*dayResolver, error) {
+	days, _, err := r.l.daysByWeek.load(r.week.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newDayResolvers(r.l, days), nil
+}
+
+type dayResolver struct {
+	l   *loaders
+	day service.Day
+}
+
+func newDayResolvers(l *loaders, days []service.Day) []*dayResolver {
+	resolvers := make([]*dayResolver, len(days))
+	ids := make([]int, len(days))
+	weekIDs := make([]int, len(days))
+	for i, day := range days {
+		resolvers[i] = &dayResolver{l: l, day: day}
+		ids[i] = day.ID
+		weekIDs[i] = day.WeekID
+	}
+	l.workoutsByDay.prime(ids...)
+	l.mealsByDay.prime(ids...)
+	l.weeks.prime(weekIDs...)
+	return resolvers
+}
+
+func (r *dayResolver) ID() graphql.ID  { return formatID(r.day.ID) }
+func (r *dayResolver) DayDate() string { return r.day.DayDate }
+
+func (r *dayResolver) Week() (*weekResolver, error) {
+	week, err := loadOne(r.l.weeks, r.day.WeekID)
+	if err != nil {
+		return nil, err
+	}
+	return newWeekResolvers(r.l, []service.Week{week})[0], nil
+}
+
+func (r *dayResolver) Workouts(args firstArgs) ([]*workoutResolver, error) {
+	workouts, _, err := r.l.workoutsByDay.load(r.day.ID)
+	if err == nil {
+		workouts, err = firstOf(workouts, args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newWorkoutResolvers(r.l, workouts), nil
+}
+
+func (r *dayResolver) Meals(args firstArgs) ([]*mealResolver, error) {
+	meals, _, err := r.l.mealsByDay.load(r.day.ID)
+	if err == nil {
+		meals, err = firstOf(meals, args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newMealResolvers(r.l, meals), nil
+}
+
+type workoutResolver struct {
+	l       *loaders
+	workout service.Workout
+}
+
+func newWorkoutResolvers(l *loaders, workouts []service.Workout) []*workoutResolver {
+	resolvers := make([]*workoutResolver, len(workouts))
+	ids := make([]int, len(workouts))
+	dayIDs := make([]int, len(workouts))
+	for i, workout := range workouts {
+		resolvers[i] = &workoutResolver{l: l, workout: workout}
+		ids[i] = workout.ID
+		dayIDs[i] = workout.DayID
+	}
+	l.liftsByWorkout.prime(ids...)
+	l.days.prime(dayIDs...)
+	return resolvers
+}
+
+func (r *workoutResolver) ID() graphql.ID  { return formatID(r.workout.ID) }
+func (r *workoutResolver) Name() string    { return r.workout.Name }
+func (r *workoutResolver) Duration() int32 { return int32(r.workout.Duration) }
+func (r *workoutResolver) Time() string    { return r.workout.Time.Format(time.RFC3339) }
+
+func (r *workoutResolver) Day() (*dayResolver, error) {
+	day, err := loadOne(r.l.days, r.workout.DayID)
+	if err != nil {
+		return nil, err
+	}
+	return newDayResolvers(r.l, []service.Day{day})[0], nil
+}
+
+func (r *workoutResolver) Lifts(args firstArgs) ([]*liftResolver, error) {
+	lifts, _, err := r.l.liftsByWorkout.load(r.workout.ID)
+	if err == nil {
+		lifts, err = firstOf(lifts, args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newLiftResolvers(r.l, lifts), nil
+}
+
+type liftResolver struct {
+	l    *loaders
+	lift service.Lift
+}
+
+func newLiftResolvers(l *loaders, lifts []service.Lift) []*liftResolver {
+	resolvers := make([]*liftResolver, len(lifts))
+	workoutIDs := make([]int, len(lifts))
+	for i, lift := range lifts {
+		resolvers[i] = &liftResolver{l: l, lift: lift}
+		workoutIDs[i] = lift.WorkoutID
+	}
+	l.workouts.prime(workoutIDs...)
+	return resolvers
+}
+
+func (r *liftResolver) ID() graphql.ID   { return formatID(r.lift.ID) }
+func (r *liftResolver) Name() string     { return r.lift.Name }
+func (r *liftResolver) Weight() float64  { return r.lift.Weight }
+func (r *liftResolver) Reps() int32      { return int32(r.lift.Reps) }
+func (r *liftResolver) LiftOrder() int32 { return int32(r.lift.LiftOrder) }
+func (r *liftResolver) RestTime() int32  { return int32(r.lift.RestTime) }
+func (r *liftResolver) BPM() int32       { return int32(r.lift.BPM) }
+
+func (r *liftResolver) Workout() (*workoutResolver, error) {
+	workout, err := loadOne(r.l.workouts, r.lift.WorkoutID)
+	if err != nil {
+		return nil, err
+	}
+	return newWorkoutResolvers(r.l, []service.Workout{workout})[0], nil
+}
+
+type mealResolver struct {
+	l    *loaders
+	meal service.Meal
+}
+
+func newMealResolvers(l *loaders, meals []service.Meal) []*mealResolver {
+	resolvers := make([]*mealResolver, len(meals))
+	dayIDs := make([]int, len(meals))
+	for i, meal := range meals {
+		resolvers[i] = &mealResolver{l: l, meal: meal}
+		dayIDs[i] = meal.DayID
+	}
+	l.days.prime(dayIDs...)
+	return resolvers
+}
+
+func (r *mealResolver) ID() graphql.ID  { return formatID(r.meal.ID) }
+func (r *mealResolver) Name() string    { return r.meal.Name }
+func (r *mealResolver) Calories() int32 { return int32(r.meal.Calories) }
+
+func (r *mealResolver) Day() (*dayResolver, error) {
+	day, err := loadOne(r.l.days, r.meal.DayID)
+	if err != nil {
+		return nil, err
+	}
+	return newDayResolvers(r.l, []service.Day{day})[0], nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"lab8-go/service"
+)
+
+func TestGraphQLListsArePaged(t *testing.T) {
+	for query, want := range map[string]string{
+		`{ weeks(first: 0) { items { id } } }`:                  "first: must be an integer between 1 and 500",
+		`{ days(first: 501) { items { id } nextCursor } }`:      "first: must be an integer between 1 and 500",
+		`{ weeks(after: "bm90IGEgY3Vyc29y") { items { id } } }`: "after: is invalid",
+		`{ days(from: "2024-02-30") { items { id } } }`:         "from: must be a date",
+	} {
+		resp := schema.Exec(context.Background(), query, "", nil)
+		if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, want) {
+			t.Errorf("%s: errors %v, want %q", query, resp.Errors, want)
+		}
+	}
+}
+
+func TestGraphQLNestedListsAreCapped(t *testing.T) {
+	lifts := make([]int, 80)
+	if got, err := firstOf(lifts, firstArgs{}); err != nil || len(got) != service.DefaultPageLimit {
+		t.Errorf("default: %d lifts, %v; want %d", len(got), err, service.DefaultPageLimit)
+	}
+	three := int32(3)
+	if got, err := firstOf(lifts, firstArgs{First: &three}); err != nil || len(got) != 3 {
+		t.Errorf("first 3: %d lifts, %v", len(got), err)
+	}
+	tooMany := int32(service.MaxPageLimit + 1)
+	if _, err := firstOf(lifts, firstArgs{First: &tooMany}); err == nil {
+		t.Error("first above the cap was accepted")
+	}
+}
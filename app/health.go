@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// schemaTables are the tables initDB creates. /readyz reports any that
+// are missing.
+var schemaTables = []string{"weeks", "days", "workouts", "meals", "lifts", "lift_sets", "workout_sessions", "tags", "workout_tags", "endpoint_visits", "route_stats_hourly", "route_latency_hourly", "route_clients_hourly", "idempotency_keys"}
+
+// schemaApplied is set once initDB has created the schema.
+var schemaApplied atomic.Bool
+
+var startedAt = time.Now()
+
+// waitForDB pings the database until it answers, backing off from 250ms
+// up to 5s between attempts, and fails once db.connect_timeout has passed.
+// Postgres often starts accepting connections a few seconds after its
+// container starts.
+func waitForDB() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DB.ConnectTimeout)
+	defer cancel()
+
+	delay := 250 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			if attempt > 1 {
+				slog.Info("Connected to the database", "attempts", attempt)
+			}
+			return nil
+		}
+		// Sleep for the delay plus up to 20% jitter.
+		wait := delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+		slog.Warn("Database not ready", "attempt", attempt, "err", err, "retry_in", wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database not reachable after %s: %w", cfg.DB.ConnectTimeout, err)
+		case <-time.After(wait):
+		}
+		delay = min(delay*2, 5*time.Second)
+	}
+}
+
+func addHealthRoutes() {
+	handleJSON("GET /healthz", healthzHandler)
+	handleJSON("GET /readyz", readyzHandler)
+}
+
+type healthStatus struct {
+	Status string `json:"status"`
+	Uptime string `json:"uptime"`
+}
+
+// readiness is the /readyz body. Status is "ready" only when every check
+// is "ok".
+type readiness struct {
+	Status string          `json:"status"`
+	Checks readinessChecks `json:"checks"`
+}
+
+type readinessChecks struct {
+	Database   readinessCheck `json:"database"`
+	Migrations readinessCheck `json:"migrations"`
+}
+
+type readinessCheck struct {
+	Status    string   `json:"status"`
+	Error     string   `json:"error,omitempty"`
+	LatencyMS *float64 `json:"latency_ms,omitempty"`
+	Missing   []string `json:"missing,omitempty"`
+}
+
+// healthzHandler answers as long as the process is serving requests; it
+// does not touch the database.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthStatus{Status: "ok", Uptime: time.Since(startedAt).Round(time.Second).String()})
+}
+
+// readyzHandler answers 200 when the database is reachable and the schema
+// is in place, and 503 with the failing checks otherwise.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	var body readiness
+	body.Status = "ready"
+	fail := func(check *readinessCheck, status string, err error) {
+		body.Status = "not_ready"
+		check.Status = status
+		if err != nil {
+			check.Error = err.Error()
+		}
+	}
+
+	start := time.Now()
+	if err := db.PingContext(ctx); err != nil {
+		fail(&body.Checks.Database, "error", err)
+		fail(&body.Checks.Migrations, "unknown", nil)
+	} else {
+		ms := float64(time.Since(start).Microseconds()) / 1000
+		body.Checks.Database = readinessCheck{Status: "ok", LatencyMS: &ms}
+
+		missing, err := missingTables(ctx)
+		switch {
+		case err != nil:
+			fail(&body.Checks.Migrations, "error", err)
+		case !schemaApplied.Load() || len(missing) > 0:
+			fail(&body.Checks.Migrations, "pending", nil)
+			body.Checks.Migrations.Missing = missing
+		default:
+			body.Checks.Migrations.Status = "ok"
+		}
+	}
+
+	status := http.StatusOK
+	if body.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, body)
+}
+
+func missingTables(ctx context.Context) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT t FROM unnest($1::text[]) AS t WHERE to_regclass(t) IS NULL", pq.Array(schemaTables))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var missing []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		missing = append(missing, t)
+	}
+	return missing, rows.Err()
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"lab8-go/service"
+)
+
+// idempotencyTTL is how long a stored Idempotency-Key is honored. It is
+// set from the idempotency_ttl setting at startup.
+var idempotencyTTL = 24 * time.Hour
+
+const maxIdempotencyKeyLength = 255
+
+// Problem codes for misused keys.
+const (
+	codeIdempotencyKeyReused = "idempotency_key_reused"
+	codeIdempotencyKeyInUse  = "idempotency_key_in_use"
+)
+
+// initIdempotency creates the key table and starts the sweep that removes
+// expired keys.
+func initIdempotency() {
+	// status is NULL while the first request with a key is still running.
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS idempotency_keys (
+            key TEXT PRIMARY KEY,
+            request_hash TEXT NOT NULL,
+            status INTEGER,
+            headers JSONB,
+            body BYTEA,
+            created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+        );`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go func() {
+		for range time.Tick(time.Hour) {
+			if _, err := db.Exec("DELETE FROM idempotency_keys WHERE created_at < now() - $1 * interval '1 second'", idempotencyTTL.Seconds()); err != nil {
+				slog.Error("Error removing expired idempotency keys", "err", err)
+			}
+		}
+	}()
+}
+
+// replayedHeaders are the response headers stored with a key and sent
+// again on a replay.
+var replayedHeaders = []string{"Content-Type", "Location", "X-Total-Count", "X-Next-Cursor", "Link"}
+
+// idempotencyKeys stores the keys. It is a variable so tests can swap in
+// a store that does not need the database.
+var idempotencyKeys keyStore = postgresKeys{}
+
+// keyStore keeps each Idempotency-Key with the request it was first used
+// for and, once that request is done, its response.
+type keyStore interface {
+	// claim records key as in use and reports whether this request got
+	// it. An expired key is replaced.
+	claim(key, hash string) (bool, error)
+	// release forgets a key whose request did not complete, so a retry
+	// runs again.
+	release(key string) error
+	// lookup returns what is stored for key, or sql.ErrNoRows.
+	lookup(key string) (storedResponse, error)
+	save(key string, resp storedResponse) error
+}
+
+// storedResponse is a key's request hash and response. status is 0 while
+// the first request with the key is still running.
+type storedResponse struct {
+	hash    string
+	status  int
+	headers map[string]string
+	body    []byte
+}
+
+// idempotent honors the Idempotency-Key header on a POST route. The first
+// request with a key runs normally and its response is stored together with
+// a hash of the request; a retry with the same key and an identical request
+// gets the stored response back with Idempotent-Replayed: true, while
+// reusing the key for a different request is rejected. Server errors and
+// panics are not stored, so the client can retry them. Requests without
+// the header are not affected.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			p := newProblem(http.StatusBadRequest, codeInvalidParam, "The Idempotency-Key header is too long.")
+			p.Errors = []service.FieldError{{Field: "Idempotency-Key", Code: "too_long", Message: "must be at most 255 characters"}}
+			writeProblem(w, r, p)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeProblem(w, r, readError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(r, body)
+
+		claimed, err := idempotencyKeys.claim(key, hash)
+		if err != nil {
+			writeErr(w, r, "claiming idempotency key", err)
+			return
+		}
+		if !claimed {
+			replayIdempotent(w, r, key, hash)
+			return
+		}
+
+		// recoverPanics turns a panic into a 500 further out, but the key
+		// would stay claimed and every retry answered "in progress".
+		defer func() {
+			if p := recover(); p != nil {
+				releaseIdempotencyKey(r, key)
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		if rec.status >= 500 {
+			releaseIdempotencyKey(r, key)
+			return
+		}
+		if err := idempotencyKeys.save(key, rec.stored()); err != nil {
+			slog.ErrorContext(r.Context(), "Error storing idempotent response", "err", err)
+		}
+	}
+}
+
+func releaseIdempotencyKey(r *http.Request, key string) {
+	if err := idempotencyKeys.release(key); err != nil {
+		slog.ErrorContext(r.Context(), "Error releasing idempotency key", "err", err)
+	}
+}
+
+// requestHash identifies a request by method, path, query, content type
+// and body, so a key cannot be reused for a different request.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n"+r.Header.Get("Content-Type")+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replayIdempotent(w http.ResponseWriter, r *http.Request, key, hash string) {
+	stored, err := idempotencyKeys.lookup(key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The first request failed and released the key in the meantime.
+		writeProblem(w, r, newProblem(http.StatusConflict, codeIdempotencyKeyInUse, "The request with this Idempotency-Key was not completed; retry it."))
+		return
+	}
+	if err != nil {
+		writeErr(w, r, "loading idempotency key", err)
+		return
+	}
+
+	switch {
+	case stored.hash != hash:
+		writeProblem(w, r, newProblem(http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "This Idempotency-Key was already used for a different request."))
+	case stored.status == 0:
+		w.Header().Set("Retry-After", "1")
+		writeProblem(w, r, newProblem(http.StatusConflict, codeIdempotencyKeyInUse, "A request with this Idempotency-Key is still in progress."))
+	default:
+		for k, v := range stored.headers {
+			w.Header().Set(k, v)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.status)
+		w.Write(stored.body)
+	}
+}
+
+// postgresKeys stores the keys in the idempotency_keys table, so they are
+// shared by every instance and survive restarts.
+type postgresKeys struct{}
+
+func (postgresKeys) claim(key, hash string) (bool, error) {
+	if _, err := db.Exec("DELETE FROM idempotency_keys WHERE key = $1 AND created_at < now() - $2 * interval '1 second'", key, idempotencyTTL.Seconds()); err != nil {
+		return false, err
+	}
+	res, err := db.Exec("INSERT INTO idempotency_keys (key, request_hash) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING", key, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (postgresKeys) release(key string) error {
+	_, err := db.Exec("DELETE FROM idempotency_keys WHERE key = $1", key)
+	return err
+}
+
+func (postgresKeys) lookup(key string) (storedResponse, error) {
+	var stored storedResponse
+	var status sql.NullInt64
+	var headers []byte
+	err := db.QueryRow("SELECT request_hash, status, headers, body FROM idempotency_keys WHERE key = $1", key).
+		Scan(&stored.hash, &status, &headers, &stored.body)
+	if err != nil {
+		return stored, err
+	}
+	stored.status = int(status.Int64)
+	json.Unmarshal(headers, &stored.headers)
+	return stored, nil
+}
+
+func (postgresKeys) save(key string, resp storedResponse) error {
+	encoded, err := json.Marshal(resp.headers)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE idempotency_keys SET status = $2, headers = $3, body = $4 WHERE key = $1",
+		key, resp.status, string(encoded), resp.body)
+	return err
+}
+
+// responseRecorder passes a response through while keeping a copy of its
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+// stored is the response to keep for an idempotency key.
+func (rec *responseRecorder) stored() storedResponse {
+	headers := map[string]string{}
+	for _, name := range replayedHeaders {
+		if v := rec.Header().Get(name); v != "" {
+			headers[name] = v
+		}
+	}
+	return storedResponse{status: rec.status, headers: headers, body: rec.body.Bytes()}
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// isCreateRoute reports whether a handleJSON pattern takes an
+// Idempotency-Key.
+func isCreateRoute(pattern string) bool {
+	return strings.HasPrefix(pattern, "POST ")
+}
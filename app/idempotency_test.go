@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryKeys is a keyStore for tests.
+type memoryKeys struct {
+	mu   sync.Mutex
+	keys map[string]storedResponse
+}
+
+func (m *memoryKeys) claim(key, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.keys[key]; ok {
+		return false, nil
+	}
+	m.keys[key] = storedResponse{hash: hash}
+	return true, nil
+}
+
+func (m *memoryKeys) release(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, key)
+	return nil
+}
+
+func (m *memoryKeys) lookup(key string) (storedResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.keys[key]
+	if !ok {
+		return stored, sql.ErrNoRows
+	}
+	return stored, nil
+}
+
+func (m *memoryKeys) save(key string, resp storedResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	resp.hash = m.keys[key].hash
+	m.keys[key] = resp
+	return nil
+}
+
+func TestIdempotentReleasesKeyOnPanic(t *testing.T) {
+	idempotencyKeys = &memoryKeys{keys: map[string]storedResponse{}}
+	defer func() { idempotencyKeys = postgresKeys{} }()
+
+	calls := 0
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("database went away")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	post := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/v1/weeks", strings.NewReader(`{"start_date":"2024-03-04"}`))
+		r.Header.Set("Idempotency-Key", "retry-me")
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic was swallowed instead of reaching recoverPanics")
+			}
+		}()
+		post()
+	}()
+
+	if rec := post(); rec.Code != http.StatusCreated || calls != 2 {
+		t.Fatalf("retry: status %d after %d calls, want 201 from a second call: %s", rec.Code, calls, rec.Body)
+	}
+	if rec := post(); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "true" || calls != 2 {
+		t.Errorf("second retry: status %d, replayed %q after %d calls; want the stored 201", rec.Code, rec.Header().Get("Idempotent-Replayed"), calls)
+	}
+}
+
+func TestAddFormReplaysRedirect(t *testing.T) {
+	idempotencyKeys = &memoryKeys{keys: map[string]storedResponse{}}
+	defer func() { idempotencyKeys = postgresKeys{} }()
+	// Only the first post may reach the database.
+	useFakeDB(t,
+		fakeResult{row: []driver.Value{int64(1)}}, // the day's week
+		fakeResult{row: []driver.Value{int64(11), int64(3), "Push", int64(60), time.Now()}},
+	)
+
+	handler := idempotent(addFormHandler)
+	for i, replayed := range []string{"", "true"} {
+		r := httptest.NewRequest("POST", "/add-form", strings.NewReader("day_id=3&name=Push&duration=60"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Idempotency-Key", "form-retry")
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/workouts?day_id=3" ||
+			rec.Header().Get("Idempotent-Replayed") != replayed {
+			t.Errorf("post %d: status %d, Location %q, replayed %q: %s", i+1, rec.Code,
+				rec.Header().Get("Location"), rec.Header().Get("Idempotent-Replayed"), rec.Body)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestIDAndAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(contextHandler{slog.NewJSONHandler(&logs, nil)}))
+
+	handler := withRequestID(accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "inside")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})))
+
+	r := httptest.NewRequest("GET", "/teapot", nil)
+	r.Header.Set("X-Request-ID", "abc-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if got := rec.Header().Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("X-Request-ID = %q, want the caller's abc-123", got)
+	}
+
+	dec := json.NewDecoder(&logs)
+	var inside, access map[string]any
+	if err := dec.Decode(&inside); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&access); err != nil {
+		t.Fatal(err)
+	}
+	if inside["request_id"] != "abc-123" {
+		t.Errorf("handler log line = %v, want request_id abc-123", inside)
+	}
+	if access["msg"] != "request" || access["request_id"] != "abc-123" || access["status"] != 418.0 || access["bytes"] != 15.0 || access["path"] != "/teapot" {
+		t.Errorf("access log line = %v", access)
+	}
+
+	r = httptest.NewRequest("GET", "/teapot", nil)
+	r.Header.Set("X-Request-ID", "bad id\r\nSet-Cookie: x")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if got := rec.Header().Get("X-Request-ID"); len(got) != 32 {
+		t.Errorf("X-Request-ID = %q, want a new 32-character ID in place of an invalid one", got)
+	}
+}
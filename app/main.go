@@ -2,64 +2,151 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 
 	_ "github.com/lib/pq"
+
+	"lab8-go/service"
 )
 
 var db *sql.DB
 
+// svc is the domain service every handler goes through.
+var svc *service.Service
+
+// cfg is the resolved configuration (see config.go).
+var cfg *Config
+
 func main() {
-	// Check if an IP address is provided
-	if len(os.Args) < 2 {
-		fmt.Println("Please provide an IP address")
+	var settings []*setting
+	var args []string
+	var err error
+	cfg, settings, args, err = loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
-	ip := os.Args[1]
+	if cfg == nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var command string
+	if len(args) > 0 {
+		command = args[0]
+	}
+	if command == "config" {
+		if len(args) != 2 || args[1] != "show" {
+			fmt.Fprintln(os.Stderr, "usage: config show")
+			os.Exit(2)
+		}
+		showConfig(os.Stdout, settings)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	initLogging(os.Stderr)
+	devMode = cfg.Dev
+	idempotencyTTL = cfg.IdempotencyTTL
+
+	switch {
+	case command == "" || command == "repair-calendar":
+	case net.ParseIP(command) != nil:
+		// The listen address used to be the only argument.
+		cfg.HTTP.Address = command
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q; commands are config show and repair-calendar [-fix]\n", command)
+		os.Exit(2)
+	}
 
 	// Initialize database connection
 	initDB()
 	defer db.Close()
 
+	if command == "repair-calendar" {
+		repairCalendar(args[1:])
+		return
+	}
+
 	// Add routes and start the server
+	initTemplates()
+	addHealthRoutes()
 	addPages()
 	addEndpoints()
+	addAPIRoutes()
+	addLogRoutes()
+	addNestedRoutes()
+	addEventStream()
+	addSessionRoutes()
+	addTagRoutes()
+	addSearch()
+	if cfg.Features.GraphQL {
+		addGraphQL()
+	}
+	if cfg.Features.Docs {
+		addDocs()
+	}
+	if cfg.Features.Analytics {
+		addAnalytics()
+	}
 
-	log.Println("Server is running on port 8080")
-	http.ListenAndServe(ip+":8080", nil)
-}
+	if cfg.Features.Analytics {
+		startVisitFlusher()
+	}
+	if cfg.Features.Metrics {
+		addMetrics()
+	}
 
-func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+	mux := http.DefaultServeMux
+	if err := serve(chain(mux, middlewares(mux)...)); err != nil {
+		db.Close()
+		slog.Error("Server failed", "err", err)
+		os.Exit(1)
 	}
-	return fallback
 }
 
 func initDB() {
     var err error
-    connStr := fmt.Sprintf(
-        "host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-        getEnv("DB_HOST", "host.docker.internal"),
-        getEnv("DB_PORT", "5432"),
-        getEnv("DB_USER", "postgres"),
-        getEnv("DB_PASSWORD", "password"),
-        getEnv("DB_NAME", "testdb"),
-    )
-    db, err = sql.Open("postgres", connStr)
+    db, err = sql.Open("postgres", cfg.dsn())
     if err != nil {
         log.Fatal(err)
     }
+    db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+    db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+    db.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime)
+    if err := waitForDB(); err != nil {
+        log.Fatal(err)
+    }
 
     createWeeksTable()
     createDaysTable()
     createWorkoutsTable()
     createMealsTable()
     createLiftsTable()
+    createLiftSetsTable()
+    createWorkoutSessionsTable()
+    createTagsTables()
+    createSearchIndexes()
     createEndpointVisitsTable()
+    createRouteStatsTables()
+    initCalendar()
+    initIdempotency()
+    schemaApplied.Store(true)
+
+    weekStart, _ := parseWeekday(cfg.WeekStart) // checked by cfg.validate
+    svc = service.New(db, weekStart)
 }
 
 func createWorkoutsTable() {
@@ -97,6 +184,78 @@ func createLiftsTable() {
 	}
 }
 
+func createLiftSetsTable() {
+	createTableQuery := `
+	    CREATE TABLE IF NOT EXISTS lift_sets (
+		id SERIAL PRIMARY KEY,
+		lift_id INTEGER NOT NULL,
+		set_number INTEGER NOT NULL,
+		weight DOUBLE PRECISION NOT NULL,
+		reps INTEGER NOT NULL,
+		logged_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (lift_id, set_number),
+		FOREIGN KEY (lift_id) REFERENCES lifts(id) ON DELETE CASCADE
+	    );`
+	_, err := db.Exec(createTableQuery)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// createWorkoutSessionsTable creates the live sessions (see
+// service.Session). rest_until is when the rest started by the last set
+// ends.
+func createWorkoutSessionsTable() {
+	createTableQuery := `
+	    CREATE TABLE IF NOT EXISTS workout_sessions (
+		workout_id INTEGER PRIMARY KEY,
+		started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		finished_at TIMESTAMPTZ,
+		rest_lift_id INTEGER,
+		rest_until TIMESTAMPTZ,
+		FOREIGN KEY (workout_id) REFERENCES workouts(id) ON DELETE CASCADE,
+		FOREIGN KEY (rest_lift_id) REFERENCES lifts(id) ON DELETE SET NULL
+	    );`
+	_, err := db.Exec(createTableQuery)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// createTagsTables creates the tags and the workouts they are on. Tag
+// names are stored lowercase, so the unique constraint ignores case.
+func createTagsTables() {
+	createTablesQuery := `
+	    CREATE TABLE IF NOT EXISTS tags (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	    );
+	    CREATE TABLE IF NOT EXISTS workout_tags (
+		workout_id INTEGER NOT NULL,
+		tag_id INTEGER NOT NULL,
+		PRIMARY KEY (workout_id, tag_id),
+		FOREIGN KEY (workout_id) REFERENCES workouts(id) ON DELETE CASCADE,
+		FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+	    );
+	    CREATE INDEX IF NOT EXISTS workout_tags_tag_id ON workout_tags (tag_id);`
+	_, err := db.Exec(createTablesQuery)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// createSearchIndexes creates the full-text indexes service.Search uses.
+// Their expressions must match the ones in its query.
+func createSearchIndexes() {
+	_, err := db.Exec(`
+	    CREATE INDEX IF NOT EXISTS workouts_name_search ON workouts USING GIN (to_tsvector('english', name));
+	    CREATE INDEX IF NOT EXISTS lifts_name_search ON lifts USING GIN (to_tsvector('english', name));
+	    CREATE INDEX IF NOT EXISTS meals_name_search ON meals USING GIN (to_tsvector('english', name));`)
+	if err != nil {
+		log.Fatalf("Error creating search indexes: %v", err)
+	}
+}
+
 func createWeeksTable() {
     createTableQuery := `
     CREATE TABLE IF NOT EXISTS weeks (
@@ -139,35 +298,58 @@ func createMealsTable() {
 }
 
 func createEndpointVisitsTable() {
+    // Visits used to be seeded for a fixed list of endpoints named without
+    // the leading slash; they are now counted for every route by its path
+    // pattern (see visits.go).
     createTableQuery := `
     CREATE TABLE IF NOT EXISTS endpoint_visits (
         endpoint TEXT PRIMARY KEY,
-        visit_count INTEGER DEFAULT 0
+        visit_count BIGINT DEFAULT 0
     );
+    ALTER TABLE endpoint_visits ALTER COLUMN visit_count TYPE BIGINT;
+    UPDATE endpoint_visits SET endpoint = '/' || endpoint WHERE endpoint NOT LIKE '/%';
     `
     _, err := db.Exec(createTableQuery)
     if err != nil {
         log.Fatalf("Error creating endpoint_visits table: %v", err)
     }
 
-    endpoints := []string{
-        "add-workout", "list-workouts", "add-lift", "delete-workout",
-        "add-week", "add-day", "add-meal",
-    }
+    log.Println("Endpoint visits table setup complete.")
+}
 
-    insertEndpointQuery := `
-    INSERT INTO endpoint_visits (endpoint, visit_count) 
-    VALUES ($1, 0) 
-    ON CONFLICT (endpoint) DO NOTHING;
+// createRouteStatsTables creates the hourly per-route statistics written
+// by the visit flusher. Latency is kept as histogram buckets and clients
+// as hashed keys, so percentiles and unique counts can be computed over
+// any range of hours.
+func createRouteStatsTables() {
+    createTableQuery := `
+    CREATE TABLE IF NOT EXISTS route_stats_hourly (
+        route TEXT NOT NULL,
+        hour TIMESTAMPTZ NOT NULL,
+        requests BIGINT NOT NULL DEFAULT 0,
+        errors BIGINT NOT NULL DEFAULT 0,
+        PRIMARY KEY (route, hour)
+    );
+    CREATE INDEX IF NOT EXISTS route_stats_hourly_hour ON route_stats_hourly (hour);
+    CREATE TABLE IF NOT EXISTS route_latency_hourly (
+        route TEXT NOT NULL,
+        hour TIMESTAMPTZ NOT NULL,
+        le_ms DOUBLE PRECISION NOT NULL,
+        count BIGINT NOT NULL DEFAULT 0,
+        PRIMARY KEY (route, hour, le_ms)
+    );
+    CREATE INDEX IF NOT EXISTS route_latency_hourly_hour ON route_latency_hourly (hour);
+    CREATE TABLE IF NOT EXISTS route_clients_hourly (
+        route TEXT NOT NULL,
+        hour TIMESTAMPTZ NOT NULL,
+        client TEXT NOT NULL,
+        PRIMARY KEY (route, hour, client)
+    );
+    CREATE INDEX IF NOT EXISTS route_clients_hourly_hour ON route_clients_hourly (hour);
     `
-
-    for _, endpoint := range endpoints {
-        _, err := db.Exec(insertEndpointQuery, endpoint)
-        if err != nil {
-            log.Printf("Error initializing endpoint %s: %v", endpoint, err)
-        }
+    _, err := db.Exec(createTableQuery)
+    if err != nil {
+        log.Fatalf("Error creating route statistics tables: %v", err)
     }
-
-    log.Println("Endpoint visits table setup complete.")
 }
 
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"lab8-go/service"
+)
+
+// metrics is the registry behind /metrics. It holds the Go runtime,
+// process and connection pool collectors besides the ones below.
+var metrics = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time to serve HTTP requests by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	httpInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests being served by route.",
+	}, []string{"route"})
+)
+
+// domainCounters count the changes the service publishes, such as
+// workouts and lifts logged, whichever route or page made them.
+var domainCounters = map[service.EventType]prometheus.Counter{
+	service.WeekCreated:    domainCounter("weeks_created_total", "Weeks created."),
+	service.DayCreated:     domainCounter("days_created_total", "Days created."),
+	service.WorkoutCreated: domainCounter("workouts_logged_total", "Workouts logged."),
+	service.WorkoutDeleted: domainCounter("workouts_deleted_total", "Workouts deleted."),
+	service.LiftCreated:    domainCounter("lifts_logged_total", "Lifts logged."),
+	service.SetLogged:      domainCounter("sets_logged_total", "Lift sets logged."),
+	service.MealCreated:    domainCounter("meals_logged_total", "Meals logged."),
+}
+
+func domainCounter(name, help string) prometheus.Counter {
+	return prometheus.NewCounter(prometheus.CounterOpts{Namespace: "workout_tracker", Name: name, Help: help})
+}
+
+// addMetrics registers the collectors and serves them at /metrics. It
+// needs db and svc, so it runs after initDB.
+func addMetrics() {
+	metrics.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "postgres"),
+		httpRequests, httpDuration, httpInFlight,
+	)
+	for _, c := range domainCounters {
+		metrics.MustRegister(c)
+	}
+	svc.Subscribe(func(e service.Event) {
+		if c, ok := domainCounters[e.Type]; ok {
+			c.Inc()
+		}
+	})
+
+	http.Handle("GET /metrics", promhttp.HandlerFor(metrics, promhttp.HandlerOpts{}))
+}
+
+// instrument records the request count, latency and in-flight gauge of
+// every request, labelled by the route pattern mux matches rather than the
+// raw path, so /api/v1/workouts/1 and /api/v1/workouts/2 share a series.
+func instrument(mux *http.ServeMux) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routeLabel(mux, r)
+			inFlight := httpInFlight.WithLabelValues(route)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+
+			httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+			httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+		})
+	}
+}
+
+// routeLabel returns the path of the pattern mux routes r to, without its
+// method, or "unmatched" for a request no route takes.
+func routeLabel(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	if pattern == "" {
+		return "unmatched"
+	}
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
+
+// statusWriter remembers the status code and counts the bytes written
+// through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for
+// flushing and deadlines.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteLabel(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/workouts/{id}", func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc("/static/", func(http.ResponseWriter, *http.Request) {})
+
+	for path, want := range map[string]string{
+		"/api/v1/workouts/7":  "/api/v1/workouts/{id}",
+		"/static/styles.css":  "/static/",
+		"/no/such/route":      "unmatched",
+		"/api/v1/workouts/7/": "unmatched",
+	} {
+		if got := routeLabel(mux, httptest.NewRequest("GET", path, nil)); got != want {
+			t.Errorf("routeLabel(%s) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestStatusWriterKeepsFirstStatus(t *testing.T) {
+	sw := &statusWriter{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+	sw.WriteHeader(http.StatusCreated)
+	sw.WriteHeader(http.StatusInternalServerError)
+	if sw.status != http.StatusCreated {
+		t.Errorf("status = %d, want %d", sw.status, http.StatusCreated)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// middleware wraps a handler with behavior shared by every route.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in mws so the first one listed runs first.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// middlewares returns the chain every request goes through, outermost
+// first. The request ID comes first so every later log line has it; the
+// access log, metrics and visit counts sit outside recoverPanics so they
+// see the 500 a panic turns into. Rate limiting comes after CORS so a
+// browser can read its 429.
+func middlewares(mux *http.ServeMux) []middleware {
+	mws := []middleware{withRequestID, accessLog}
+	if cfg.Features.Metrics {
+		mws = append(mws, instrument(mux))
+	}
+	if cfg.Features.Analytics {
+		mws = append(mws, countVisits(mux))
+	}
+	mws = append(mws, recoverPanics, securityHeaders)
+	if origins := cfg.corsOrigins(); len(origins) > 0 {
+		mws = append(mws, corsForAPI(mux, origins))
+	}
+	if cfg.RateLimit.Enabled {
+		mws = append(mws, rateLimit(newLimiter()))
+	}
+	mws = append(mws, csrfProtect(cfg.corsOrigins()))
+	if cfg.HTTP.Compress {
+		mws = append(mws, compress)
+	}
+	return mws
+}
+
+// recoverPanics turns a panicking handler into a 500 and logs the panic
+// with its stack, instead of dropping the connection. Browsers get the
+// error page and API clients a problem. If the handler had already
+// started its response there is nothing clean left to send, and the
+// connection is closed.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(v)
+			}
+			slog.ErrorContext(r.Context(), "Panic serving request", "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if sw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			writeInternalError(sw, r)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// errorPage is the data of templates/error.html.
+type errorPage struct {
+	Status    int
+	Title     string
+	RequestID string
+}
+
+// writeInternalError answers a request that failed unexpectedly, as a
+// page for browsers and as a problem otherwise.
+func writeInternalError(w http.ResponseWriter, r *http.Request) {
+	w.Header().Del("Content-Encoding")
+	w.Header().Del("Content-Length")
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		writeProblem(w, r, newProblem(http.StatusInternalServerError, codeInternal, "The server failed to handle the request."))
+		return
+	}
+	renderPageStatus(w, r, http.StatusInternalServerError, "error", errorPage{
+		Status:    http.StatusInternalServerError,
+		Title:     http.StatusText(http.StatusInternalServerError),
+		RequestID: requestIDFrom(r.Context()),
+	})
+}
+
+// securityHeaders sets the Content-Security-Policy from security.csp,
+// stops browsers from sniffing content types or framing the pages, and
+// asks them to stay on HTTPS when TLS is on.
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		if cfg.Security.CSP != "" {
+			h.Set("Content-Security-Policy", cfg.Security.CSP)
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "same-origin")
+		if cfg.TLS.CertFile != "" {
+			h.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsHeaders are the request headers a browser may send cross-origin to
+// the API, and corsExposed the response headers its scripts may read.
+var (
+	corsHeaders = "Content-Type, Idempotency-Key, X-Request-ID, X-CSRF-Token"
+	corsExposed = "X-Request-ID, X-Next-Cursor, X-Total-Count, Link, Location, Retry-After, " +
+		"RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy"
+)
+
+// corsForAPI lets pages on the allowed origins call the JSON API routes
+// (those registered with handleJSON) from a browser, and answers their
+// preflight requests. Other routes get no CORS headers. It must be built
+// after every route is registered.
+func corsForAPI(mux *http.ServeMux, origins []string) middleware {
+	allowed := map[string]bool{}
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	api := map[string]bool{}
+	for _, pattern := range jsonRoutes {
+		api[pattern] = true
+	}
+	maxAge := strconv.Itoa(int(cfg.CORS.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || (!allowed["*"] && !allowed[origin]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			method := r.Method
+			if preflight {
+				method = r.Header.Get("Access-Control-Request-Method")
+			}
+			probe := *r
+			probe.Method = method
+			if _, pattern := mux.Handler(&probe); !api[pattern] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if allowed["*"] {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if !preflight {
+				h.Set("Access-Control-Expose-Headers", corsExposed)
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Set("Access-Control-Allow-Methods", method)
+			h.Set("Access-Control-Allow-Headers", corsHeaders)
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
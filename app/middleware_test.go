@@ -0,0 +1,129 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	cfg = defaultConfig()
+	initTemplates()
+	handler := withRequestID(recoverPanics(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/weeks", nil))
+	var p problem
+	if rec.Code != 500 || json.Unmarshal(rec.Body.Bytes(), &p) != nil || p.Code != codeInternal {
+		t.Errorf("API request: status %d, body %s; want a 500 problem", rec.Code, rec.Body)
+	}
+
+	req := httptest.NewRequest("GET", "/weeks", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	id := rec.Header().Get("X-Request-ID")
+	if rec.Code != 500 || !strings.Contains(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), id) {
+		t.Errorf("page request: status %d, body %s; want the error page with request ID %s", rec.Code, rec.Body, id)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	big := strings.Repeat(`{"name": "bench press"}`, 100)
+	handler := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/small" {
+			w.Header().Set("Content-Length", "2")
+			io.WriteString(w, "{}")
+			return
+		}
+		io.WriteString(w, big)
+	}))
+
+	for _, tc := range []struct {
+		path, accept, want string
+	}{
+		{"/big", "gzip, deflate", "gzip"},
+		{"/big", "gzip;q=1.0, br;q=0.5", "gzip"},
+		{"/big", "br, gzip", "br"},
+		{"/big", "gzip;q=0, identity", ""},
+		{"/small", "gzip", ""},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Accept-Encoding", tc.accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Content-Encoding"); got != tc.want {
+			t.Errorf("%s with Accept-Encoding %q: Content-Encoding %q, want %q", tc.path, tc.accept, got, tc.want)
+			continue
+		}
+		if tc.want == "gzip" {
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, _ := io.ReadAll(zr); string(body) != big {
+				t.Errorf("gzip body does not round-trip")
+			}
+		}
+	}
+}
+
+func TestCORSForAPIRoutes(t *testing.T) {
+	cfg = defaultConfig()
+	mux := http.NewServeMux()
+	defer func(saved []string) { jsonRoutes = saved }(jsonRoutes)
+	jsonRoutes = []string{"POST /cors-test/api"}
+	mux.HandleFunc("POST /cors-test/api", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /cors-test/page", func(w http.ResponseWriter, r *http.Request) {})
+	handler := corsForAPI(mux, []string{"https://app.example.com"})(mux)
+
+	preflight := func(origin, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preflight("https://app.example.com", "/cors-test/api")
+	if rec.Code != 204 || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || rec.Header().Get("Access-Control-Allow-Methods") != "POST" {
+		t.Errorf("allowed preflight: status %d, headers %v", rec.Code, rec.Header())
+	}
+	for _, rec := range []*httptest.ResponseRecorder{
+		preflight("https://evil.example.com", "/cors-test/api"),
+		preflight("https://app.example.com", "/cors-test/page"),
+	} {
+		if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("unexpected CORS headers %v", rec.Header())
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	cfg = defaultConfig()
+	handler := securityHeaders(http.NotFoundHandler())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Header().Get("Content-Security-Policy") != defaultCSP || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("headers %v", rec.Header())
+	}
+	if rec.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS sent without TLS")
+	}
+
+	cfg.TLS.CertFile, cfg.TLS.KeyFile = "cert.pem", "key.pem"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("no HSTS with TLS on")
+	}
+}
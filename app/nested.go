@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"lab8-go/service"
+)
+
+func addNestedRoutes() {
+	handleJSON("POST /workouts", createWorkoutTreeHandler)
+}
+
+// createWorkoutTreeHandler creates a whole workout with its lifts and sets
+// in one transaction (see service.WorkoutDocument).
+func createWorkoutTreeHandler(w http.ResponseWriter, r *http.Request) {
+	var doc service.WorkoutDocument
+	if !decodeJSON(w, r, &doc) {
+		return
+	}
+
+	tree, err := svc.CreateWorkoutTree(r.Context(), doc)
+	if err != nil {
+		writeErr(w, r, "creating workout", service.RequireParent("day_id", err))
+		return
+	}
+	writeCreated(w, fmt.Sprintf("/api/v1/workouts/%d", tree.ID), tree)
+}
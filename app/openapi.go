@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"lab8-go/service"
+)
+
+// addDocs serves the OpenAPI document and a small explorer page for it.
+func addDocs() {
+	http.HandleFunc("GET /openapi.json", openAPIHandler)
+	http.HandleFunc("GET /docs", docsPageHandler)
+}
+
+// apiOperation documents one JSON route. Request and response bodies are
+// given as zero values of the Go types the handlers decode and encode, and
+// their schemas are derived from those types by reflection.
+type apiOperation struct {
+	Method   string
+	Path     string
+	Summary  string
+	Tag      string
+	Query    []apiParam
+	Body     any
+	Form     bool // Body is sent as application/x-www-form-urlencoded
+	Status   int
+	Response any
+	List     bool // Response is a page: {"items": [...], "next_cursor": ..., "total": ...}
+}
+
+type apiParam struct {
+	Name        string
+	Type        string
+	Required    bool
+	Description string
+}
+
+// legacyStatus is the {"status": "success", ...} body the original
+// endpoints answer with.
+type legacyStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type legacyWorkoutCreated struct {
+	Status string `json:"status"`
+	ID     int    `json:"id"`
+}
+
+type legacyDayInput struct {
+	WeekID  int    `json:"week_id"`
+	DayDate string `json:"day_date" format:"date"`
+}
+
+type legacyDayCreated struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	DayID   int    `json:"dayID"`
+}
+
+// apiOperations must describe every route registered with handleJSON;
+// openapi_test.go enforces this.
+var apiOperations = []apiOperation{
+	{Method: "GET", Path: "/api/v1/weeks", Summary: "List weeks, newest first", Tag: "weeks", Status: 200, Response: service.Week{}, List: true, Query: listParams(service.WeekList.Params())},
+	{Method: "POST", Path: "/api/v1/weeks", Summary: "Create a week", Tag: "weeks", Body: service.WeekInput{}, Status: 201, Response: service.Week{}},
+	{Method: "GET", Path: "/api/v1/weeks/{id}", Summary: "Get a week", Tag: "weeks", Status: 200, Response: service.Week{}},
+	{Method: "DELETE", Path: "/api/v1/weeks/{id}", Summary: "Delete a week and everything in it", Tag: "weeks", Status: 204},
+
+	{Method: "GET", Path: "/api/v1/weeks/{id}/days", Summary: "List the days in a week", Tag: "days", Status: 200, Response: service.Day{}, List: true, Query: listParams(service.DayList.Params())},
+	{Method: "POST", Path: "/api/v1/weeks/{id}/days", Summary: "Add a day to a week", Tag: "days", Body: service.DayInput{}, Status: 201, Response: service.Day{}},
+	{Method: "GET", Path: "/api/v1/days/{id}", Summary: "Get a day", Tag: "days", Status: 200, Response: service.Day{}},
+	{Method: "DELETE", Path: "/api/v1/days/{id}", Summary: "Delete a day and everything in it", Tag: "days", Status: 204},
+
+	{Method: "GET", Path: "/api/v1/days/{id}/workouts", Summary: "List the workouts on a day", Tag: "workouts", Status: 200, Response: service.Workout{}, List: true, Query: listParams(service.WorkoutList.Params())},
+	{Method: "POST", Path: "/api/v1/days/{id}/workouts", Summary: "Add a workout to a day", Tag: "workouts", Body: service.WorkoutInput{}, Status: 201, Response: service.Workout{}},
+	{Method: "GET", Path: "/api/v1/workouts/{id}", Summary: "Get a workout", Tag: "workouts", Status: 200, Response: service.Workout{}},
+	{Method: "DELETE", Path: "/api/v1/workouts/{id}", Summary: "Delete a workout and its lifts", Tag: "workouts", Status: 204},
+
+	{Method: "POST", Path: "/api/v1/days/{id}/sessions", Summary: "Start a live session: a new workout on the day, timed by the server", Tag: "sessions", Body: service.SessionInput{}, Status: 201, Response: service.Session{}},
+	{Method: "GET", Path: "/api/v1/workouts/{id}/session", Summary: "Get a workout's session with its lifts, sets and rest countdown", Tag: "sessions", Status: 200, Response: service.Session{}},
+	{Method: "POST", Path: "/api/v1/workouts/{id}/session/sets", Summary: "Log a set now and start its lift's rest; 409 once the session is finished", Tag: "sessions", Body: service.SessionSetInput{}, Status: 201, Response: service.Session{}},
+	{Method: "POST", Path: "/api/v1/workouts/{id}/session/finish", Summary: "Finish a session, setting the workout's time and duration; 409 if already finished", Tag: "sessions", Status: 200, Response: service.Session{}},
+
+	{Method: "GET", Path: "/api/v1/tags", Summary: "List tags by name", Tag: "tags", Status: 200, Response: service.Tag{}, List: true, Query: listParams(service.TagList.Params())},
+	{Method: "POST", Path: "/api/v1/tags", Summary: "Create a tag; names are stored lowercase and must be unique", Tag: "tags", Body: service.TagInput{}, Status: 201, Response: service.Tag{}},
+	{Method: "GET", Path: "/api/v1/tags/{id}", Summary: "Get a tag", Tag: "tags", Status: 200, Response: service.Tag{}},
+	{Method: "PUT", Path: "/api/v1/tags/{id}", Summary: "Rename a tag on every workout that has it", Tag: "tags", Body: service.TagInput{}, Status: 200, Response: service.Tag{}},
+	{Method: "DELETE", Path: "/api/v1/tags/{id}", Summary: "Delete a tag, removing it from its workouts", Tag: "tags", Status: 204},
+	{Method: "GET", Path: "/api/v1/workouts/{id}/tags", Summary: "List a workout's tags", Tag: "tags", Status: 200, Response: []service.Tag{}},
+	{Method: "PUT", Path: "/api/v1/workouts/{id}/tags", Summary: "Replace a workout's tags, creating any that do not exist", Tag: "tags", Body: service.WorkoutTagsInput{}, Status: 200, Response: []service.Tag{}},
+	{Method: "DELETE", Path: "/api/v1/workouts/{id}/tags/{tag_id}", Summary: "Remove a tag from a workout", Tag: "tags", Status: 204},
+	{Method: "GET", Path: "/api/v1/weeks/{id}/tag-totals", Summary: "Add up a week's workouts by tag: sessions, minutes and volume", Tag: "tags", Status: 200, Response: []service.TagTotal{}},
+
+	{Method: "GET", Path: "/api/v1/workouts/{id}/lifts", Summary: "List the lifts in a workout", Tag: "lifts", Status: 200, Response: service.Lift{}, List: true, Query: listParams(service.LiftList.Params())},
+	{Method: "POST", Path: "/api/v1/workouts/{id}/lifts", Summary: "Add a lift to a workout", Tag: "lifts", Body: service.LiftInput{}, Status: 201, Response: service.Lift{}},
+	{Method: "GET", Path: "/api/v1/lifts/{id}", Summary: "Get a lift", Tag: "lifts", Status: 200, Response: service.Lift{}},
+	{Method: "DELETE", Path: "/api/v1/lifts/{id}", Summary: "Delete a lift", Tag: "lifts", Status: 204},
+
+	{Method: "GET", Path: "/api/v1/days/{id}/meals", Summary: "List the meals on a day", Tag: "meals", Status: 200, Response: service.Meal{}, List: true, Query: listParams(service.MealList.Params())},
+	{Method: "POST", Path: "/api/v1/days/{id}/meals", Summary: "Add a meal to a day", Tag: "meals", Body: service.MealInput{}, Status: 201, Response: service.Meal{}},
+	{Method: "GET", Path: "/api/v1/meals/{id}", Summary: "Get a meal", Tag: "meals", Status: 200, Response: service.Meal{}},
+	{Method: "DELETE", Path: "/api/v1/meals/{id}", Summary: "Delete a meal", Tag: "meals", Status: 204},
+
+	{Method: "POST", Path: "/log/{date}/workouts", Summary: "Log a workout on a date, creating its week and day if needed", Tag: "log", Body: service.WorkoutInput{}, Status: 201, Response: service.Workout{}},
+	{Method: "POST", Path: "/log/{date}/meals", Summary: "Log a meal on a date, creating its week and day if needed", Tag: "log", Body: service.MealInput{}, Status: 201, Response: service.Meal{}},
+
+	{Method: "POST", Path: "/workouts", Summary: "Create a workout with its lifts and sets in one transaction", Tag: "workouts", Body: service.WorkoutDocument{}, Status: 201, Response: service.WorkoutTree{}},
+
+	{Method: "GET", Path: "/healthz", Summary: "Report that the process is alive", Tag: "health", Status: 200, Response: healthStatus{}},
+	{Method: "GET", Path: "/readyz", Summary: "Report whether the database is reachable and the schema is applied; 503 if not", Tag: "health", Status: 200, Response: readiness{}},
+
+	{Method: "GET", Path: "/api/v1/search", Summary: "Search workout, lift and meal names, ranked and grouped by type", Tag: "search", Query: searchParams, Status: 200, Response: service.SearchResults{}},
+
+	{Method: "GET", Path: "/api/v1/analytics", Summary: "Report requests, errors, p50/p95 latency and unique clients per route and over time", Tag: "analytics", Query: analyticsParams, Status: 200, Response: analyticsReport{}},
+
+	{Method: "POST", Path: "/graphql", Summary: "Run a GraphQL query or mutation over weeks, days, workouts, lifts and meals", Tag: "graphql", Body: graphqlRequest{}, Status: 200, Response: graphqlResponse{}},
+
+	{Method: "POST", Path: "/add-week", Summary: "Create a week (legacy)", Tag: "legacy", Body: service.WeekInput{}, Status: 200},
+	{Method: "POST", Path: "/add-day", Summary: "Add a day to a week (legacy)", Tag: "legacy", Body: legacyDayInput{}, Form: true, Status: 200, Response: legacyDayCreated{}},
+	{Method: "POST", Path: "/add-workout", Summary: "Add a workout to a day (legacy)", Tag: "legacy", Body: legacyWorkoutInput{}, Status: 200, Response: legacyWorkoutCreated{}},
+	{Method: "GET", Path: "/list-workouts", Summary: "List the workouts on a day (legacy)", Tag: "legacy", Query: append([]apiParam{{Name: "day_id", Type: "integer", Required: true}}, listParams(service.WorkoutList.Params())...), Status: 200, Response: []service.Workout{}},
+	{Method: "POST", Path: "/delete-workout", Summary: "Delete a workout (legacy)", Tag: "legacy", Body: legacyDeleteInput{}, Status: 200},
+	{Method: "POST", Path: "/add-lift", Summary: "Add a lift to a workout (legacy)", Tag: "legacy", Body: legacyLiftInput{}, Status: 200, Response: legacyStatus{}},
+	{Method: "GET", Path: "/list-lifts", Summary: "List the lifts in a workout (legacy)", Tag: "legacy", Query: append([]apiParam{{Name: "workout_id", Type: "integer", Required: true}}, listParams(service.LiftList.Params())...), Status: 200, Response: []service.Lift{}},
+	{Method: "POST", Path: "/add-meal", Summary: "Add a meal to a day (legacy)", Tag: "legacy", Body: legacyMealInput{}, Status: 200, Response: legacyStatus{}},
+}
+
+// listParams documents the query parameters of a paged list.
+func listParams(docs []service.ParamDoc) []apiParam {
+	params := make([]apiParam, len(docs))
+	for i, d := range docs {
+		params[i] = apiParam{Name: d.Name, Type: d.Type, Description: d.Description}
+	}
+	return params
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPISpec())
+}
+
+func docsPageHandler(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, r, "docs", nil)
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPISpec builds the OpenAPI 3 document from apiOperations.
+func openAPISpec() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]any{}
+
+	for _, op := range apiOperations {
+		operation := map[string]any{
+			"summary":     op.Summary,
+			"operationId": operationID(op),
+			"tags":        []string{op.Tag},
+		}
+
+		var params []any
+		for _, m := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			schema := map[string]any{"type": "integer", "minimum": 1}
+			if m[1] == "date" {
+				schema = map[string]any{"type": "string", "format": "date"}
+			}
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true, "schema": schema,
+			})
+		}
+		for _, q := range op.Query {
+			param := map[string]any{
+				"name": q.Name, "in": "query", "required": q.Required,
+				"schema": map[string]any{"type": q.Type},
+			}
+			if q.Description != "" {
+				param["description"] = q.Description
+			}
+			params = append(params, param)
+		}
+		if isCreateRoute(op.Method + " " + op.Path) {
+			params = append(params, map[string]any{
+				"name": "Idempotency-Key", "in": "header",
+				"description": "Makes retries safe: a repeat of the same request with the same key replays the first response",
+				"schema":      map[string]any{"type": "string", "maxLength": maxIdempotencyKeyLength},
+			})
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+
+		if op.Body != nil {
+			contentType := "application/json"
+			if op.Form {
+				contentType = "application/x-www-form-urlencoded"
+			}
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					contentType: map[string]any{"schema": schemaOf(reflect.TypeOf(op.Body), schemas)},
+				},
+			}
+		}
+
+		success := map[string]any{"description": http.StatusText(op.Status)}
+		if op.Response != nil {
+			schema := schemaOf(reflect.TypeOf(op.Response), schemas)
+			if op.List {
+				schema = map[string]any{
+					"type":     "object",
+					"required": []string{"items", "next_cursor"},
+					"properties": map[string]any{
+						"items":       map[string]any{"type": "array", "items": schema},
+						"next_cursor": map[string]any{"type": "string", "nullable": true, "description": "Pass as cursor to fetch the next page; null on the last page"},
+						"total":       map[string]any{"type": "integer", "description": "Number of matching rows, present when total=true"},
+					},
+				}
+			}
+			success["content"] = map[string]any{"application/json": map[string]any{"schema": schema}}
+		}
+		if op.Status == http.StatusCreated {
+			success["headers"] = map[string]any{
+				"Location": map[string]any{"description": "URL of the created resource", "schema": map[string]any{"type": "string"}},
+			}
+		}
+		operation["responses"] = map[string]any{
+			strconv.Itoa(op.Status): success,
+			"default": map[string]any{
+				"description": "Problem details (RFC 7807)",
+				"content": map[string]any{
+					"application/problem+json": map[string]any{"schema": schemaOf(reflect.TypeOf(problem{}), schemas)},
+				},
+			},
+		}
+
+		item, _ := paths[op.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Workout Tracker API",
+			"version":     "1.0.0",
+			"description": "Weeks contain days, days contain workouts and meals, and workouts contain lifts.",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+// schemaOf returns the JSON schema for t. Named struct types are added to
+// schemas and referenced by name.
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.Number("")):
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "integer"},
+			map[string]any{"type": "string", "pattern": "^[0-9]+$"},
+		}}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Map:
+		return map[string]any{"type": "object"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // guards against recursive types
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	// VisibleFields includes the fields promoted from embedded inputs.
+	for _, f := range reflect.VisibleFields(t) {
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous || !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		schema := schemaOf(f.Type, schemas)
+		if format := f.Tag.Get("format"); format != "" {
+			schema["format"] = format
+		}
+		properties[name] = schema
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// schemaName exports the Go type name, so weekInput becomes WeekInput.
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// operationID derives a stable identifier such as "getApiV1WeeksIdDays".
+func operationID(op apiOperation) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '-'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
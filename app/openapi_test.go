@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDescribesRegisteredRoutes(t *testing.T) {
+	addEndpoints()
+	addAPIRoutes()
+	addLogRoutes()
+	addNestedRoutes()
+	addSessionRoutes()
+	addTagRoutes()
+	addSearch()
+	addGraphQL()
+	addHealthRoutes()
+	addAnalytics()
+	if len(jsonRoutes) == 0 {
+		t.Fatal("no JSON routes were registered")
+	}
+
+	spec := openAPISpec()
+	if _, err := json.Marshal(spec); err != nil {
+		t.Fatalf("spec does not encode as JSON: %v", err)
+	}
+	paths := spec["paths"].(map[string]any)
+
+	registered := map[string]bool{}
+	for _, pattern := range jsonRoutes {
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			t.Errorf("route %q has no method, so it cannot be documented", pattern)
+			continue
+		}
+		registered[strings.ToLower(method)+" "+path] = true
+
+		item, _ := paths[path].(map[string]any)
+		if item[strings.ToLower(method)] == nil {
+			t.Errorf("route %q is registered but missing from /openapi.json", pattern)
+		}
+	}
+
+	for path, item := range paths {
+		for method := range item.(map[string]any) {
+			if !registered[method+" "+path] {
+				t.Errorf("/openapi.json documents %s %s, which is not registered", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"lab8-go/service"
+)
+
+// problem is an RFC 7807 problem details object. Every JSON endpoint
+// reports failures with one, served as application/problem+json.
+type problem struct {
+	Type     string               `json:"type"`
+	Title    string               `json:"title"`
+	Status   int                  `json:"status"`
+	Detail   string               `json:"detail,omitempty"`
+	Instance string               `json:"instance,omitempty"`
+	Code     string               `json:"code"`
+	Errors   []service.FieldError `json:"errors,omitempty"`
+}
+
+// Machine-readable problem codes.
+const (
+	codeValidation   = "validation_failed"
+	codeInvalidJSON  = "invalid_json"
+	codeInvalidParam = "invalid_parameter"
+	codeNotFound     = "not_found"
+	codeConflict     = "conflict"
+	codeInternal     = "internal_error"
+	codeBodyTooLarge = "body_too_large"
+)
+
+func newProblem(status int, code, detail string) *problem {
+	return &problem{
+		Type:   "urn:workout-tracker:problem:" + strings.ReplaceAll(code, "_", "-"),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p *problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding problem", "err", err)
+	}
+}
+
+// writeErr maps an error to a problem: validation and parameter errors
+// become 400s, missing rows 404s, conflicts 409s, and anything else is logged and
+// reported as a 500 without leaking details. action describes what failed,
+// such as "creating workout".
+func writeErr(w http.ResponseWriter, r *http.Request, action string, err error) {
+	var verr *service.ValidationError
+	var perr *service.ParamError
+	var cerr *service.ConflictError
+	switch {
+	case errors.As(err, &cerr):
+		p := newProblem(http.StatusConflict, codeConflict, "The request conflicts with existing data.")
+		p.Errors = cerr.Fields
+		writeProblem(w, r, p)
+	case errors.As(err, &verr):
+		p := newProblem(http.StatusBadRequest, codeValidation, "The request has invalid fields.")
+		p.Errors = verr.Fields
+		writeProblem(w, r, p)
+	case errors.As(err, &perr):
+		p := newProblem(http.StatusBadRequest, codeInvalidParam, perr.Error())
+		p.Errors = []service.FieldError{{Field: perr.Param, Code: "invalid", Message: perr.Message}}
+		writeProblem(w, r, p)
+	case errors.Is(err, service.ErrNotFound):
+		writeProblem(w, r, newProblem(http.StatusNotFound, codeNotFound, "The requested resource does not exist."))
+	default:
+		slog.ErrorContext(r.Context(), "Error "+action, "err", err)
+		writeProblem(w, r, newProblem(http.StatusInternalServerError, codeInternal, "Error "+action+"."))
+	}
+}
+
+// decodeError describes a JSON decoding failure, naming the offending
+// field when there is one.
+func decodeError(err error) *problem {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return readError(err)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		p := newProblem(http.StatusBadRequest, codeInvalidJSON, "The request body has a field of the wrong type.")
+		p.Errors = []service.FieldError{{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: fmt.Sprintf("must be a %s", jsonTypeName(typeErr.Type.Kind().String())),
+		}}
+		return p
+	case errors.As(err, &syntaxErr):
+		return newProblem(http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("The request body is not valid JSON (at byte %d).", syntaxErr.Offset))
+	case errors.Is(err, io.EOF):
+		return newProblem(http.StatusBadRequest, codeInvalidJSON, "The request body is empty.")
+	default:
+		return newProblem(http.StatusBadRequest, codeInvalidJSON, "The request body must be valid JSON.")
+	}
+}
+
+// readError describes a request body that could not be read, usually
+// because it is over the http.max_body_bytes limit.
+func readError(err error) *problem {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return newProblem(http.StatusRequestEntityTooLarge, codeBodyTooLarge,
+			fmt.Sprintf("The request body is larger than %d bytes.", tooLarge.Limit))
+	}
+	return newProblem(http.StatusBadRequest, codeInvalidJSON, "The request body could not be read.")
+}
+
+func jsonTypeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"):
+		return "integer"
+	case strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice":
+		return "list"
+	case kind == "struct", kind == "map":
+		return "object"
+	default:
+		return kind
+	}
+}
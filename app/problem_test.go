@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lab8-go/service"
+)
+
+// decodeProblem checks that rec holds a problem+json response and
+// decodes it.
+func decodeProblem(t *testing.T, rec *httptest.ResponseRecorder) problem {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type %q, want application/problem+json", ct)
+	}
+	var p problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("body is not a problem: %v: %s", err, rec.Body)
+	}
+	if p.Status != rec.Code {
+		t.Errorf("problem status %d, response status %d", p.Status, rec.Code)
+	}
+	return p
+}
+
+func fieldNames(errs []service.FieldError) []string {
+	names := make([]string, len(errs))
+	for i, e := range errs {
+		names[i] = e.Field
+	}
+	return names
+}
+
+func TestWriteErrMapsErrorsToProblems(t *testing.T) {
+	nested := (&service.WorkoutDocument{DayID: 1, Name: "Push", Duration: 60, Lifts: []service.LiftDocument{
+		{LiftInput: service.LiftInput{Name: "Bench", Weight: 100, Reps: 5}},
+		{LiftInput: service.LiftInput{Name: "Dips"}, Sets: []service.SetInput{{Weight: 10, Reps: 0}}},
+	}}).Validate()
+
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		fields []string
+	}{
+		{"validation", &service.ValidationError{Fields: []service.FieldError{{Field: "name", Code: "required", Message: "is required"}}}, 400, codeValidation, []string{"name"}},
+		{"nested validation", nested, 400, codeValidation, []string{"lifts[1].reps", "lifts[1].sets[0].reps"}},
+		{"conflict", &service.ConflictError{Fields: []service.FieldError{{Field: "day_date", Code: "duplicate", Message: "already has a day"}}}, 409, codeConflict, []string{"day_date"}},
+		{"param", &service.ParamError{Param: "limit", Message: "must be an integer"}, 400, codeInvalidParam, []string{"limit"}},
+		{"not found", service.ErrNotFound, 404, codeNotFound, nil},
+		{"wrapped not found", fmt.Errorf("fetching day: %w", service.ErrNotFound), 404, codeNotFound, nil},
+		{"internal", errors.New("pq: password authentication failed for user secret"), 500, codeInternal, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeErr(rec, httptest.NewRequest("POST", "/api/v1/days/1/workouts", nil), "creating workout", tc.err)
+			if rec.Code != tc.status {
+				t.Errorf("status %d, want %d", rec.Code, tc.status)
+			}
+			p := decodeProblem(t, rec)
+			if p.Code != tc.code || p.Instance != "/api/v1/days/1/workouts" {
+				t.Errorf("code %q instance %q, want %q for the request path", p.Code, p.Instance, tc.code)
+			}
+			if got := fieldNames(p.Errors); strings.Join(got, ",") != strings.Join(tc.fields, ",") {
+				t.Errorf("fields %v, want %v", got, tc.fields)
+			}
+			if strings.Contains(rec.Body.String(), "secret") || strings.Contains(rec.Body.String(), "pq:") {
+				t.Errorf("problem leaks the error: %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONRejectsMalformedBodies(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"name": Push}`:     "not valid JSON (at byte 10)",
+		`{"name": "Push",`:   "must be valid JSON",
+		``:                   "empty",
+		`{"duration": "60"}`: "wrong type",
+	} {
+		rec := httptest.NewRecorder()
+		var in service.WorkoutInput
+		if decodeJSON(rec, httptest.NewRequest("POST", "/api/v1/days/1/workouts", strings.NewReader(body)), &in) {
+			t.Errorf("%q was decoded", body)
+			continue
+		}
+		p := decodeProblem(t, rec)
+		if rec.Code != 400 || p.Code != codeInvalidJSON || !strings.Contains(p.Detail, want) {
+			t.Errorf("%q: status %d code %q detail %q, want a 400 %s saying %q", body, rec.Code, p.Code, p.Detail, codeInvalidJSON, want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const codeRateLimited = "rate_limited"
+
+// rateRule is a token bucket: it holds up to burst tokens, refills at
+// perSecond, and every request takes one.
+type rateRule struct {
+	group     string // "read" or "write"
+	perSecond float64
+	burst     int
+}
+
+// fullAfter is how long an empty bucket takes to refill.
+func (rule rateRule) fullAfter() time.Duration {
+	return time.Duration(float64(rule.burst) / rule.perSecond * float64(time.Second))
+}
+
+// bucketState is what a limiter answers for one request: whether it may
+// proceed and how many tokens the bucket has left.
+type bucketState struct {
+	allowed   bool
+	remaining float64
+}
+
+// limiter keeps the buckets. memoryLimiter serves one instance;
+// postgresLimiter shares the buckets between instances.
+type limiter interface {
+	take(ctx context.Context, key string, rule rateRule, now time.Time) (bucketState, error)
+	// prune forgets buckets idle for longer than idle, which are full.
+	prune(ctx context.Context, idle time.Duration) error
+}
+
+// rateRules returns the rule for a request, or false for requests that
+// are never limited: health checks, metrics, static files and preflights.
+func rateRules(r *http.Request) (rateRule, bool) {
+	switch {
+	case r.Method == http.MethodOptions,
+		r.URL.Path == "/healthz", r.URL.Path == "/readyz", r.URL.Path == "/metrics",
+		strings.HasPrefix(r.URL.Path, "/static/"):
+		return rateRule{}, false
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return rateRule{"read", float64(cfg.RateLimit.ReadPerMinute) / 60, cfg.RateLimit.ReadBurst}, true
+	default:
+		return rateRule{"write", float64(cfg.RateLimit.WritePerMinute) / 60, cfg.RateLimit.WriteBurst}, true
+	}
+}
+
+// clientIdentity is who a request is limited as: the ratelimit.key_header
+// value when that header is configured and present, or the client's IP.
+func clientIdentity(r *http.Request) string {
+	if h := cfg.RateLimit.KeyHeader; h != "" {
+		if id := r.Header.Get(h); id != "" {
+			return "key:" + id
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// newLimiter returns the limiter ratelimit.store asks for and starts
+// pruning it.
+func newLimiter() limiter {
+	var l limiter = &memoryLimiter{buckets: map[string]*bucket{}}
+	if cfg.RateLimit.Store == "postgres" {
+		initRateLimitTable()
+		l = postgresLimiter{}
+	}
+
+	// A bucket idle for longer than the slowest refill is full again, the
+	// same as having no bucket.
+	idle := max(
+		rateRule{perSecond: float64(cfg.RateLimit.ReadPerMinute) / 60, burst: cfg.RateLimit.ReadBurst}.fullAfter(),
+		rateRule{perSecond: float64(cfg.RateLimit.WritePerMinute) / 60, burst: cfg.RateLimit.WriteBurst}.fullAfter(),
+	)
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := l.prune(context.Background(), idle); err != nil {
+				slog.Error("Error pruning rate limit buckets", "err", err)
+			}
+		}
+	}()
+	return l
+}
+
+// rateLimit answers 429 to clients that have used up their bucket for the
+// request's group, with Retry-After and the RateLimit headers. Allowed
+// responses carry the RateLimit headers too. If the limiter fails, the
+// request is let through rather than failing with it.
+func rateLimit(l limiter) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rule, ok := rateRules(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			state, err := l.take(r.Context(), rule.group+":"+clientIdentity(r), rule, time.Now())
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error checking rate limit", "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			window := int(math.Ceil(rule.fullAfter().Seconds()))
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rule.burst, window))
+			h.Set("RateLimit-Limit", strconv.Itoa(rule.burst))
+			h.Set("RateLimit-Remaining", strconv.Itoa(int(state.remaining)))
+			reset := (float64(rule.burst) - state.remaining) / rule.perSecond
+			if !state.allowed {
+				reset = (1 - state.remaining) / rule.perSecond
+			}
+			h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset))))
+			if state.allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(reset)))))
+			writeProblem(w, r, newProblem(http.StatusTooManyRequests, codeRateLimited,
+				fmt.Sprintf("Too many %s requests; the limit is %d at once and %d per minute.", rule.group, rule.burst, int(math.Round(rule.perSecond*60)))))
+		})
+	}
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type memoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func (l *memoryLimiter) take(_ context.Context, key string, rule rateRule, now time.Time) (bucketState, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(rule.burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rule.burst), b.tokens+now.Sub(b.updated).Seconds()*rule.perSecond)
+	b.updated = now
+	if b.tokens < 1 {
+		return bucketState{allowed: false, remaining: b.tokens}, nil
+	}
+	b.tokens--
+	return bucketState{allowed: true, remaining: b.tokens}, nil
+}
+
+func (l *memoryLimiter) prune(_ context.Context, idle time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if time.Since(b.updated) > idle {
+			delete(l.buckets, key)
+		}
+	}
+	return nil
+}
+
+func initRateLimitTable() {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+            key TEXT PRIMARY KEY,
+            tokens DOUBLE PRECISION NOT NULL,
+            allowed BOOLEAN NOT NULL,
+            updated_at TIMESTAMPTZ NOT NULL
+        );`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// postgresLimiter keeps the buckets in rate_limit_buckets and updates them
+// in one statement, timed by the database clock, so every instance draws
+// from the same bucket.
+type postgresLimiter struct{}
+
+func (postgresLimiter) take(ctx context.Context, key string, rule rateRule, _ time.Time) (bucketState, error) {
+	var state bucketState
+	err := db.QueryRowContext(ctx, `
+        INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+        VALUES ($1, $2 - 1, true, now())
+        ON CONFLICT (key) DO UPDATE SET
+            allowed = LEAST($2, b.tokens + extract(epoch FROM now() - b.updated_at) * $3) >= 1,
+            tokens = LEAST($2, b.tokens + extract(epoch FROM now() - b.updated_at) * $3)
+                - CASE WHEN LEAST($2, b.tokens + extract(epoch FROM now() - b.updated_at) * $3) >= 1 THEN 1 ELSE 0 END,
+            updated_at = now()
+        RETURNING allowed, tokens`,
+		key, float64(rule.burst), rule.perSecond,
+	).Scan(&state.allowed, &state.remaining)
+	return state, err
+}
+
+func (postgresLimiter) prune(ctx context.Context, idle time.Duration) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE updated_at < now() - $1 * interval '1 second'", idle.Seconds())
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterRefills(t *testing.T) {
+	l := &memoryLimiter{buckets: map[string]*bucket{}}
+	rule := rateRule{"write", 1, 2}
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	var got []bool
+	for _, at := range []time.Duration{0, 0, 0, 500 * time.Millisecond, time.Second, 10 * time.Second, 10 * time.Second, 10 * time.Second} {
+		state, _ := l.take(context.Background(), "k", rule, now.Add(at))
+		got = append(got, state.allowed)
+	}
+	want := []bool{true, true, false, false, true, true, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("allowed = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg = defaultConfig()
+	cfg.RateLimit.WriteBurst = 2
+	cfg.RateLimit.WritePerMinute = 6
+	handler := rateLimit(&memoryLimiter{buckets: map[string]*bucket{}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	post := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/weeks", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	post("10.0.0.1:1000")
+	rec := post("10.0.0.1:1001")
+	if rec.Code != http.StatusCreated || rec.Header().Get("RateLimit-Remaining") != "0" || rec.Header().Get("RateLimit-Policy") != "2;w=20" {
+		t.Errorf("second write: status %d, headers %v", rec.Code, rec.Header())
+	}
+
+	rec = post("10.0.0.1:1002")
+	var p problem
+	if rec.Code != http.StatusTooManyRequests || json.Unmarshal(rec.Body.Bytes(), &p) != nil || p.Code != codeRateLimited {
+		t.Errorf("third write: status %d, body %s; want a 429 problem", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Retry-After = %q, want 10", got)
+	}
+
+	if rec = post("10.0.0.2:1000"); rec.Code != http.StatusCreated {
+		t.Errorf("another client: status %d, want 201", rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/weeks", nil)
+	req.RemoteAddr = "10.0.0.1:1003"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated || rec.Header().Get("RateLimit-Limit") != "100" {
+		t.Errorf("read after writes: status %d, headers %v; want the read bucket", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// repairCalendar implements the repair-calendar command. It reports rows
+// that break the calendar invariants and, with -fix, repairs them (see
+// service.RepairCalendar) and adds any constraints that were missing. It
+// exits with status 1 when violations are found and not fixed.
+func repairCalendar(args []string) {
+	flags := flag.NewFlagSet("repair-calendar", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "repair the violations instead of only reporting them")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	violations, err := svc.CalendarViolations(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error checking calendar:", err)
+		os.Exit(1)
+	}
+	if len(violations) == 0 {
+		fmt.Println("No calendar violations found.")
+		return
+	}
+	for _, v := range violations {
+		fmt.Println(v)
+	}
+	if !*fix {
+		fmt.Printf("%d violations found; run with -fix to repair them.\n", len(violations))
+		os.Exit(1)
+	}
+
+	err = svc.RepairCalendar(ctx, func(change string) { fmt.Println(change) })
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error repairing calendar:", err)
+		os.Exit(1)
+	}
+	if missing := addCalendarConstraints(); len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "Could not add constraints %v after repair.\n", missing)
+		os.Exit(1)
+	}
+	fmt.Println("Calendar repaired.")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"lab8-go/service"
+)
+
+// defaultSearchLimit is how many results of each type a search returns
+// unless it asks for a limit.
+const defaultSearchLimit = 20
+
+// searchParams documents the query of GET /api/v1/search.
+var searchParams = []apiParam{
+	{Name: "q", Type: "string", Required: true, Description: "Words to find in workout, lift and meal names; the last may be partly typed"},
+	{Name: "limit", Type: "integer", Description: "Results of each type, 1 to 50 (default 20)"},
+}
+
+// addSearch registers the search page and its JSON API.
+func addSearch() {
+	http.HandleFunc("GET /search", searchPageHandler)
+	handleJSON("GET /api/v1/search", apiSearch)
+}
+
+// search runs the search in the request's q and limit.
+func search(r *http.Request) (service.SearchResults, error) {
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return service.SearchResults{}, &service.ParamError{Param: "limit", Message: "must be an integer"}
+		}
+		limit = n
+	}
+	return svc.Search(r.Context(), r.URL.Query().Get("q"), limit)
+}
+
+func apiSearch(w http.ResponseWriter, r *http.Request) {
+	results, err := search(r)
+	if err != nil {
+		writeErr(w, r, "searching", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// searchPage is the data of templates/search.html. Results is nil until
+// something has been searched for.
+type searchPage struct {
+	Query   string
+	Results *service.SearchResults
+}
+
+func searchPageHandler(w http.ResponseWriter, r *http.Request) {
+	page := searchPage{Query: r.URL.Query().Get("q")}
+	if page.Query != "" {
+		results, err := search(r)
+		if err != nil {
+			pageError(w, r, "searching", err)
+			return
+		}
+		page.Results = &results
+	}
+	renderPage(w, r, "search", page)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lab8-go/service"
+)
+
+func TestSearchParams(t *testing.T) {
+	for query, param := range map[string]string{
+		"":                 "q",
+		"q=%20":            "q",
+		"q=bench&limit=x":  "limit",
+		"q=bench&limit=0":  "limit",
+		"q=bench&limit=51": "limit",
+	} {
+		_, err := search(httptest.NewRequest("GET", "/api/v1/search?"+query, nil))
+		var perr *service.ParamError
+		if !errors.As(err, &perr) || perr.Param != param {
+			t.Errorf("%q: err %v, want a ParamError for %s", query, err, param)
+		}
+	}
+
+	// Punctuation alone leaves no words to search for.
+	results, err := search(httptest.NewRequest("GET", "/api/v1/search?q=%26%7C!", nil))
+	if err != nil || len(results.Workouts)+len(results.Lifts)+len(results.Meals) != 0 {
+		t.Errorf("results %v, err %v; want none", results, err)
+	}
+}
+
+func TestSearchPageGroupsResults(t *testing.T) {
+	body := renderTestPage(t, "search", searchPage{
+		Query: "bench",
+		Results: &service.SearchResults{
+			Query:    "bench",
+			Workouts: []service.SearchResult{},
+			Lifts: []service.SearchResult{{
+				Type: service.ResultLift, ID: 4, Name: "Bench Press", DayID: 9, DayDate: "2024-03-04",
+				WorkoutID: 2, WorkoutName: "Push",
+			}},
+			Meals: []service.SearchResult{},
+		},
+	})
+	for _, want := range []string{"<h2>Lifts</h2>", `href="/lifts?workout_id=2">Bench Press</a> in Push`, `href="/workouts?day_id=9">2024-03-04</a>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page is missing %s", want)
+		}
+	}
+	if strings.Contains(body, "<h2>Workouts</h2>") || strings.Contains(body, "Nothing matches") {
+		t.Errorf("page shows empty groups:\n%s", body)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// shutdownHooks run after the server has stopped taking requests and
+// before the database is closed, so anything still buffered in memory can
+// be written out.
+var (
+	shutdownHooks   []func(context.Context)
+	shutdownHooksMu sync.Mutex
+)
+
+// draining is closed when a graceful shutdown starts, so long-lived
+// responses such as event streams end instead of holding it up.
+var draining = make(chan struct{})
+
+// onShutdown registers a function to run during a graceful shutdown.
+func onShutdown(hook func(context.Context)) {
+	shutdownHooksMu.Lock()
+	defer shutdownHooksMu.Unlock()
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// serve runs the server until it fails or gets SIGINT or SIGTERM. On a
+// signal it stops accepting connections, waits up to
+// http.shutdown_timeout for in-flight requests, and runs the shutdown
+// hooks. It returns an error if the server could not bind or stopped on
+// its own.
+func serve(handler http.Handler) error {
+	server := &http.Server{
+		Addr:              cfg.listenAddr(),
+		Handler:           limitBody(handler),
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+	server.RegisterOnShutdown(func() { close(draining) })
+
+	// Bind before starting so a port that is taken fails here, with the
+	// cause, rather than in the background.
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", server.Addr, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() {
+		if cfg.TLS.CertFile != "" {
+			served <- server.ServeTLS(ln, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			served <- server.Serve(ln)
+		}
+	}()
+	slog.Info("Server is running", "addr", server.Addr)
+
+	select {
+	case err := <-served:
+		return fmt.Errorf("serving on %s: %w", server.Addr, err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process right away
+
+	slog.Info("Shutting down; waiting for in-flight requests", "timeout", cfg.HTTP.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Not all requests finished before the shutdown timeout", "err", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Error stopping server", "err", err)
+	}
+
+	shutdownHooksMu.Lock()
+	hooks := shutdownHooks
+	shutdownHooksMu.Unlock()
+	for _, hook := range hooks {
+		hook(shutdownCtx)
+	}
+	slog.Info("Server stopped")
+	return nil
+}
+
+// limitBody caps request bodies at http.max_body_bytes. Reading past the
+// limit fails, and decodeJSON answers 413.
+func limitBody(next http.Handler) http.Handler {
+	limit := int64(cfg.HTTP.MaxBodyBytes)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeProblem(w, r, readError(&http.MaxBytesError{Limit: limit}))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimitBodyRejectsLargeBodies(t *testing.T) {
+	cfg = defaultConfig()
+	cfg.HTTP.MaxBodyBytes = 16
+	handler := limitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v map[string]any
+		if decodeJSON(w, r, &v) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	for name, body := range map[string]string{
+		"small":   `{"a": 1}`,
+		"large":   `{"name": "` + strings.Repeat("x", 64) + `"}`,
+		"chunked": `{"name": "` + strings.Repeat("x", 64) + `"}`,
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		if name == "chunked" {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		want := http.StatusRequestEntityTooLarge
+		if name == "small" {
+			want = http.StatusNoContent
+		}
+		if rec.Code != want {
+			t.Errorf("%s body: status %d, want %d: %s", name, rec.Code, want, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// The *ByIDs and *By<Parent>IDs methods load many rows in one query, for
+// callers such as the GraphQL loaders that batch their lookups. The
+// *By<Parent>IDs methods return at most MaxPageLimit children per parent;
+// the paged lists reach the rest.
+
+func (s *Service) WeeksByIDs(ctx context.Context, ids []int) (map[int]Week, error) {
+	weeks, err := queryAll(ctx, s.db, scanWeek, "SELECT id, start_date FROM weeks WHERE id = ANY($1)", pq.Array(ids))
+	return byKey(weeks, err, func(w Week) int { return w.ID })
+}
+
+func (s *Service) DaysByIDs(ctx context.Context, ids []int) (map[int]Day, error) {
+	days, err := queryAll(ctx, s.db, scanDay, "SELECT id, week_id, day_date FROM days WHERE id = ANY($1)", pq.Array(ids))
+	return byKey(days, err, func(d Day) int { return d.ID })
+}
+
+func (s *Service) WorkoutsByIDs(ctx context.Context, ids []int) (map[int]Workout, error) {
+	workouts, err := queryAll(ctx, s.db, scanWorkout, "SELECT "+workoutColumns+" FROM workouts WHERE id = ANY($1)", pq.Array(ids))
+	return byKey(workouts, err, func(w Workout) int { return w.ID })
+}
+
+func (s *Service) DaysByWeekIDs(ctx context.Context, weekIDs []int) (map[int][]Day, error) {
+	days, err := queryAll(ctx, s.db, scanDay,
+		"SELECT id, week_id, day_date FROM days WHERE week_id = ANY($1) ORDER BY day_date ASC", pq.Array(weekIDs))
+	return groupBy(days, err, func(d Day) int { return d.WeekID })
+}
+
+func (s *Service) WorkoutsByDayIDs(ctx context.Context, dayIDs []int) (map[int][]Workout, error) {
+	workouts, err := queryAll(ctx, s.db, scanWorkout,
+		childrenOf(workoutColumns, "workouts", "day_id", "time, id"), pq.Array(dayIDs))
+	return groupBy(workouts, err, func(w Workout) int { return w.DayID })
+}
+
+func (s *Service) MealsByDayIDs(ctx context.Context, dayIDs []int) (map[int][]Meal, error) {
+	meals, err := queryAll(ctx, s.db, scanMeal,
+		childrenOf(mealColumns, "meals", "day_id", "id"), pq.Array(dayIDs))
+	return groupBy(meals, err, func(m Meal) int { return m.DayID })
+}
+
+func (s *Service) LiftsByWorkoutIDs(ctx context.Context, workoutIDs []int) (map[int][]Lift, error) {
+	lifts, err := queryAll(ctx, s.db, scanLift,
+		childrenOf(liftColumns, "lifts", "workout_id", "lift_order, id"), pq.Array(workoutIDs))
+	return groupBy(lifts, err, func(l Lift) int { return l.WorkoutID })
+}
+
+// TagsByWorkoutIDs returns the tags of each workout, by name.
+func (s *Service) TagsByWorkoutIDs(ctx context.Context, workoutIDs []int) (map[int][]Tag, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT wt.workout_id, t.id, t.name FROM workout_tags wt JOIN tags t ON t.id = wt.tag_id
+        WHERE wt.workout_id = ANY($1) ORDER BY t.name`, pq.Array(workoutIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := map[int][]Tag{}
+	for rows.Next() {
+		var workoutID int
+		var tag Tag
+		if err := rows.Scan(&workoutID, &tag.ID, &tag.Name); err != nil {
+			return nil, err
+		}
+		tags[workoutID] = append(tags[workoutID], tag)
+	}
+	return tags, rows.Err()
+}
+
+// childrenOf selects the rows of table whose parent column is in the
+// array $1, the first MaxPageLimit of each parent in order. The table and
+// column names are always constants from this package.
+func childrenOf(columns, table, parent, order string) string {
+	return fmt.Sprintf(`
+        SELECT %s FROM (
+            SELECT *, row_number() OVER (PARTITION BY %s ORDER BY %s) AS n FROM %s WHERE %s = ANY($1)
+        ) children WHERE n <= %d ORDER BY %s`, columns, parent, order, table, parent, MaxPageLimit, order)
+}
+
+func byKey[T any](items []T, err error, key func(T) int) (map[int]T, error) {
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[int]T, len(items))
+	for _, item := range items {
+		m[key(item)] = item
+	}
+	return m, nil
+}
+
+func groupBy[T any](items []T, err error, key func(T) int) (map[int][]T, error) {
+	if err != nil {
+		return nil, err
+	}
+	m := map[int][]T{}
+	for _, item := range items {
+		m[key(item)] = append(m[key(item)], item)
+	}
+	return m, nil
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var WeekList = ListSpec[Week]{
+	table:   "weeks",
+	columns: "id, start_date",
+	scan:    scanWeek,
+	id:      func(w Week) int { return w.ID },
+	sorts: map[string]sortField[Week]{
+		"start_date": {"start_date", "date", func(w Week) string { return w.StartDate }},
+		"id":         {"id", "integer", func(w Week) string { return strconv.Itoa(w.ID) }},
+	},
+	defaultSort: "-start_date",
+	filters: []filterField{
+		{"from", "start_date >= %s", dateFilter, "Only weeks starting on or after this date"},
+		{"to", "start_date <= %s", dateFilter, "Only weeks starting on or before this date"},
+		{"tag", "id IN (SELECT d.week_id FROM days d JOIN workouts w ON w.day_id = d.id WHERE w.id IN (" + taggedWorkouts + "))", tagFilter,
+			"Only weeks with a workout with any of these comma-separated tags"},
+	},
+}
+
+var DayList = ListSpec[Day]{
+	table:   "days",
+	columns: "id, week_id, day_date",
+	scan:    scanDay,
+	id:      func(d Day) int { return d.ID },
+	sorts: map[string]sortField[Day]{
+		"day_date": {"day_date", "date", func(d Day) string { return d.DayDate }},
+		"id":       {"id", "integer", func(d Day) string { return strconv.Itoa(d.ID) }},
+	},
+	defaultSort: "day_date",
+	filters: []filterField{
+		{"from", "day_date >= %s", dateFilter, "Only days on or after this date"},
+		{"to", "day_date <= %s", dateFilter, "Only days on or before this date"},
+		{"tag", "id IN (SELECT day_id FROM workouts WHERE id IN (" + taggedWorkouts + "))", tagFilter,
+			"Only days with a workout with any of these comma-separated tags"},
+	},
+}
+
+func (s *Service) GetWeek(ctx context.Context, id int) (Week, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT id, start_date FROM weeks WHERE id = $1", id)
+	return scanWeek(row)
+}
+
+func (s *Service) ListWeeks(ctx context.Context, p ListParams) (Page[Week], error) {
+	return WeekList.list(ctx, s.db, p, "")
+}
+
+func (s *Service) CreateWeek(ctx context.Context, in WeekInput) (Week, error) {
+	if err := in.Validate(); err != nil {
+		return Week{}, err
+	}
+	row := s.db.QueryRowContext(ctx, "INSERT INTO weeks (start_date) VALUES ($1) RETURNING id, start_date", in.StartDate)
+	week, err := scanWeek(row)
+	if err != nil {
+		return Week{}, calendarError(err)
+	}
+	s.publish(Event{Type: WeekCreated, ID: week.ID, WeekID: week.ID})
+	return week, nil
+}
+
+func (s *Service) DeleteWeek(ctx context.Context, id int) error {
+	return s.deleteByID(ctx, "weeks", id)
+}
+
+func (s *Service) GetDay(ctx context.Context, id int) (Day, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT id, week_id, day_date FROM days WHERE id = $1", id)
+	return scanDay(row)
+}
+
+// ListDays lists the days of a week, or returns ErrNotFound if the week
+// does not exist.
+func (s *Service) ListDays(ctx context.Context, weekID int, p ListParams) (Page[Day], error) {
+	if err := s.exists(ctx, "weeks", weekID); err != nil {
+		return Page[Day]{}, err
+	}
+	return DayList.list(ctx, s.db, p, "week_id = $1", weekID)
+}
+
+// CreateDay adds a day to a week, or returns ErrNotFound if the week does
+// not exist.
+func (s *Service) CreateDay(ctx context.Context, weekID int, in DayInput) (Day, error) {
+	if err := in.Validate(); err != nil {
+		return Day{}, err
+	}
+	if err := s.exists(ctx, "weeks", weekID); err != nil {
+		return Day{}, err
+	}
+	row := s.db.QueryRowContext(ctx,
+		"INSERT INTO days (week_id, day_date) VALUES ($1, $2) RETURNING id, week_id, day_date", weekID, in.DayDate)
+	day, err := scanDay(row)
+	if err != nil {
+		return Day{}, calendarError(err)
+	}
+	s.publish(Event{Type: DayCreated, ID: day.ID, WeekID: weekID, DayID: day.ID})
+	return day, nil
+}
+
+func (s *Service) DeleteDay(ctx context.Context, id int) error {
+	return s.deleteByID(ctx, "days", id)
+}
+
+// ListAllDays lists the days of every week.
+func (s *Service) ListAllDays(ctx context.Context, p ListParams) (Page[Day], error) {
+	return DayList.list(ctx, s.db, p, "")
+}
+
+func scanWeek(s scanner) (Week, error) {
+	var week Week
+	var startDate time.Time
+	if err := s.Scan(&week.ID, &startDate); err != nil {
+		return Week{}, notFound(err)
+	}
+	week.StartDate = startDate.Format(DateLayout)
+	return week, nil
+}
+
+func scanDay(s scanner) (Day, error) {
+	var day Day
+	var dayDate time.Time
+	if err := s.Scan(&day.ID, &day.WeekID, &dayDate); err != nil {
+		return Day{}, notFound(err)
+	}
+	day.DayDate = dayDate.Format(DateLayout)
+	return day, nil
+}
+
+// The calendar invariants, enforced by the database: weeks never overlap
+// (so no two share a start date), every day falls within the seven days of
+// its week, and no date has more than one day. calendarError maps their
+// violations to errors a client can act on: clashes with existing rows
+// become a ConflictError and a day outside its week a ValidationError.
+func calendarError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Constraint {
+	case "weeks_start_date_key":
+		return &ConflictError{Fields: []FieldError{{Field: "start_date", Code: "duplicate", Message: "already starts another week"}}}
+	case "weeks_no_overlap":
+		return &ConflictError{Fields: []FieldError{{Field: "start_date", Code: "overlaps", Message: "starts a week that overlaps an existing week"}}}
+	case "days_day_date_key":
+		return &ConflictError{Fields: []FieldError{{Field: "day_date", Code: "duplicate", Message: "already has a day"}}}
+	case "days_within_week":
+		return &ValidationError{Fields: []FieldError{{Field: "day_date", Code: "outside_week", Message: "must fall within its week, from start_date to six days after"}}}
+	}
+	return err
+}
+
+// StartOfWeek returns the latest date on or before date that falls on
+// weekStart.
+func StartOfWeek(date time.Time, weekStart time.Weekday) time.Time {
+	offset := (int(date.Weekday()) - int(weekStart) + 7) % 7
+	return date.AddDate(0, 0, -offset)
+}
+
+// newWeekStart picks the start of a new week containing date: the usual
+// week start, moved earlier when that week would run into the next
+// existing week, which starts on next (the zero time if there is none).
+func (s *Service) newWeekStart(date time.Time, next time.Time) time.Time {
+	start := StartOfWeek(date, s.weekStart)
+	if !next.IsZero() && next.Before(start.AddDate(0, 0, 7)) {
+		start = next.AddDate(0, 0, -7)
+	}
+	return start
+}
+
+// lockCalendar serializes everything that creates weeks or days on their
+// own until tx ends, so two requests cannot create the same one twice.
+func lockCalendar(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('calendar'))")
+	return err
+}
+
+// ResolveDay returns the day for date, creating it if needed. The day goes
+// in the existing week that contains date or, when there is none, in a new
+// week chosen by newWeekStart.
+func (s *Service) ResolveDay(ctx context.Context, date time.Time) (Day, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Day{}, err
+	}
+	defer tx.Rollback()
+
+	day, events, err := s.resolveDayTx(ctx, tx, date)
+	if err != nil {
+		return Day{}, err
+	}
+	return day, s.commit(tx, events)
+}
+
+// resolveDayTx is ResolveDay within the caller's transaction. It also
+// returns the events for the week and day it created, for the caller to
+// publish once tx commits.
+func (s *Service) resolveDayTx(ctx context.Context, tx *sql.Tx, date time.Time) (Day, []Event, error) {
+	if err := lockCalendar(ctx, tx); err != nil {
+		return Day{}, nil, err
+	}
+
+	var events []Event
+	dayDate := date.Format(DateLayout)
+	week, err := scanWeek(tx.QueryRowContext(ctx, `
+        SELECT id, start_date FROM weeks
+        WHERE start_date <= $1::date AND start_date > $1::date - 7
+        ORDER BY start_date DESC LIMIT 1`, dayDate))
+	if errors.Is(err, ErrNotFound) {
+		var next sql.NullTime
+		err = tx.QueryRowContext(ctx, "SELECT min(start_date) FROM weeks WHERE start_date > $1::date", dayDate).Scan(&next)
+		if err != nil {
+			return Day{}, nil, err
+		}
+		start := s.newWeekStart(date, next.Time).Format(DateLayout)
+		week, err = scanWeek(tx.QueryRowContext(ctx,
+			"INSERT INTO weeks (start_date) VALUES ($1) RETURNING id, start_date", start))
+		if err == nil {
+			events = append(events, Event{Type: WeekCreated, ID: week.ID, WeekID: week.ID})
+		}
+	}
+	if err != nil {
+		return Day{}, nil, calendarError(err)
+	}
+
+	day, err := scanDay(tx.QueryRowContext(ctx,
+		"SELECT id, week_id, day_date FROM days WHERE week_id = $1 AND day_date = $2 ORDER BY id LIMIT 1",
+		week.ID, dayDate))
+	if errors.Is(err, ErrNotFound) {
+		day, err = scanDay(tx.QueryRowContext(ctx,
+			"INSERT INTO days (week_id, day_date) VALUES ($1, $2) RETURNING id, week_id, day_date",
+			week.ID, dayDate))
+		if err == nil {
+			events = append(events, Event{Type: DayCreated, ID: day.ID, WeekID: week.ID, DayID: day.ID})
+		}
+	}
+	if err != nil {
+		return Day{}, nil, calendarError(err)
+	}
+	return day, events, nil
+}
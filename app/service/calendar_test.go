@@ -0,0 +1,81 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestCalendarErrorMapsConstraints(t *testing.T) {
+	for constraint, want := range map[string]struct {
+		conflict bool
+		field    string
+		code     string
+	}{
+		"weeks_start_date_key": {true, "start_date", "duplicate"},
+		"weeks_no_overlap":     {true, "start_date", "overlaps"},
+		"days_day_date_key":    {true, "day_date", "duplicate"},
+		"days_within_week":     {false, "day_date", "outside_week"},
+	} {
+		err := calendarError(fmt.Errorf("inserting: %w", &pq.Error{Code: "23505", Constraint: constraint}))
+		var fields []FieldError
+		var conflict *ConflictError
+		var invalid *ValidationError
+		switch {
+		case errors.As(err, &conflict) && want.conflict:
+			fields = conflict.Fields
+		case errors.As(err, &invalid) && !want.conflict:
+			fields = invalid.Fields
+		default:
+			t.Errorf("%s: got %T %v", constraint, err, err)
+			continue
+		}
+		if len(fields) != 1 || fields[0].Field != want.field || fields[0].Code != want.code {
+			t.Errorf("%s: fields %+v, want %s %s", constraint, fields, want.field, want.code)
+		}
+	}
+
+	// Other database errors pass through for a 500.
+	for _, err := range []error{&pq.Error{Code: "23503", Constraint: "days_week_id_fkey"}, errors.New("connection reset")} {
+		if got := calendarError(err); got != err {
+			t.Errorf("calendarError(%v) = %v, want it unchanged", err, got)
+		}
+	}
+	if calendarError(nil) != nil {
+		t.Error("calendarError(nil) is not nil")
+	}
+}
+
+func TestNewWeekStart(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse(DateLayout, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	s := New(nil, time.Monday)
+	for _, tc := range []struct{ date, next, want string }{
+		{"2024-03-06", "", "2024-03-04"},           // a Wednesday, no later week
+		{"2024-03-04", "", "2024-03-04"},           // already a Monday
+		{"2024-03-06", "2024-03-18", "2024-03-04"}, // the next week is far enough off
+		{"2024-03-06", "2024-03-11", "2024-03-04"}, // it starts right after
+		{"2024-03-06", "2024-03-09", "2024-03-02"}, // it would overlap, so start earlier
+	} {
+		var next time.Time
+		if tc.next != "" {
+			next = date(tc.next)
+		}
+		if got := s.newWeekStart(date(tc.date), next).Format(DateLayout); got != tc.want {
+			t.Errorf("newWeekStart(%s, next %q) = %s, want %s", tc.date, tc.next, got, tc.want)
+		}
+	}
+
+	s = New(nil, time.Sunday)
+	if got := s.newWeekStart(date("2024-03-06"), time.Time{}).Format(DateLayout); got != "2024-03-03" {
+		t.Errorf("with weeks starting on Sunday, newWeekStart = %s, want 2024-03-03", got)
+	}
+}
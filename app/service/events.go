@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// EventType names a change to the stored data.
+type EventType string
+
+const (
+	WeekCreated    EventType = "week.created"
+	DayCreated     EventType = "day.created"
+	WorkoutCreated EventType = "workout.created"
+	WorkoutDeleted EventType = "workout.deleted"
+	LiftCreated    EventType = "lift.created"
+	SetLogged      EventType = "set.logged"
+	MealCreated    EventType = "meal.created"
+	// SessionFinished is published when a live session ends and its
+	// workout gets its time and duration.
+	SessionFinished EventType = "session.finished"
+)
+
+// Event describes a change after it has been committed. WeekID and DayID
+// place it in the calendar, so listeners can pick the events for one week
+// or day; WorkoutID is set for lifts and sets.
+type Event struct {
+	Type      EventType `json:"type"`
+	ID        int       `json:"id"`
+	WeekID    int       `json:"week_id,omitempty"`
+	DayID     int       `json:"day_id,omitempty"`
+	WorkoutID int       `json:"workout_id,omitempty"`
+}
+
+type listeners struct {
+	mu  sync.RWMutex
+	fns []func(Event)
+}
+
+// Subscribe registers fn to be called with every event. It is called on
+// the goroutine that made the change, so it must not block.
+func (s *Service) Subscribe(fn func(Event)) {
+	s.listeners.mu.Lock()
+	defer s.listeners.mu.Unlock()
+	s.listeners.fns = append(s.listeners.fns, fn)
+}
+
+func (s *Service) publish(events ...Event) {
+	s.listeners.mu.RLock()
+	defer s.listeners.mu.RUnlock()
+	for _, e := range events {
+		for _, fn := range s.listeners.fns {
+			fn(e)
+		}
+	}
+}
+
+// dayWeek returns the week of a day, or ErrNotFound if the day does not
+// exist.
+func dayWeek(ctx context.Context, q queryer, dayID int) (int, error) {
+	var weekID int
+	err := q.QueryRowContext(ctx, "SELECT week_id FROM days WHERE id = $1", dayID).Scan(&weekID)
+	return weekID, notFound(err)
+}
+
+// workoutDay returns the day and week of a workout, or ErrNotFound if the
+// workout does not exist.
+func (s *Service) workoutDay(ctx context.Context, workoutID int) (dayID, weekID int, err error) {
+	err = s.db.QueryRowContext(ctx,
+		"SELECT d.id, d.week_id FROM workouts w JOIN days d ON d.id = w.day_id WHERE w.id = $1",
+		workoutID).Scan(&dayID, &weekID)
+	return dayID, weekID, notFound(err)
+}
+
+// commit commits tx and, once it has, publishes events.
+func (s *Service) commit(tx *sql.Tx, events []Event) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.publish(events...)
+	return nil
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// ListSpec describes how the rows of one table can be filtered, sorted and
+// paged. Pages use keyset pagination on (sort column, id), so a cursor stays
+// valid while rows are inserted or deleted around it.
+type ListSpec[T any] struct {
+	table       string
+	columns     string
+	scan        func(scanner) (T, error)
+	id          func(T) int
+	sorts       map[string]sortField[T]
+	defaultSort string // a key of sorts, prefixed with "-" for descending
+	filters     []filterField
+}
+
+type sortField[T any] struct {
+	column  string
+	sqlType string         // used to cast the cursor value back
+	value   func(T) string // the row's value for the cursor
+}
+
+// filterField maps a query parameter onto a SQL condition. cond contains a
+// single %s that is replaced with the bind parameter.
+type filterField struct {
+	param       string
+	cond        string
+	kind        filterKind
+	description string
+}
+
+type filterKind int
+
+const (
+	dateFilter filterKind = iota
+	intFilter
+	floatFilter
+	containsFilter // cond should be of the form "column ILIKE '%%' || %s || '%%'"
+	tagFilter      // a comma-separated list of tag names, bound as a text array
+)
+
+// ListParams are the parsed paging, sorting and filtering parameters of a
+// list request.
+type ListParams struct {
+	limit   int
+	sort    string
+	desc    bool
+	cursor  *pageCursor
+	total   bool
+	filters []appliedFilter
+}
+
+type appliedFilter struct {
+	cond  string
+	value any
+}
+
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// Page is one page of a list. NextCursor is null on the last page and
+// Total is only set when the client asks for it with total=true.
+type Page[T any] struct {
+	Items      []T     `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+	Total      *int    `json:"total,omitempty"`
+}
+
+// Parse reads limit, cursor, sort, total and the spec's filters from the
+// query string. Parameters the spec does not know are ignored.
+func (spec ListSpec[T]) Parse(q url.Values) (ListParams, error) {
+	p := ListParams{limit: DefaultPageLimit}
+
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxPageLimit {
+			return p, &ParamError{"limit", fmt.Sprintf("must be an integer between 1 and %d", MaxPageLimit)}
+		}
+		p.limit = n
+	}
+
+	sortKey := q.Get("sort")
+	if sortKey == "" {
+		sortKey = spec.defaultSort
+	}
+	p.sort, p.desc = strings.TrimPrefix(sortKey, "-"), strings.HasPrefix(sortKey, "-")
+	if _, ok := spec.sorts[p.sort]; !ok {
+		return p, &ParamError{"sort", "must be one of " + strings.Join(spec.sortKeys(), ", ") + ", optionally prefixed with -"}
+	}
+
+	if s := q.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil || c.Sort != sortKey || !spec.sorts[p.sort].valid(c.Value) {
+			return p, &ParamError{"cursor", "is invalid or was issued for a different sort"}
+		}
+		p.cursor = c
+	}
+
+	if s := q.Get("total"); s != "" {
+		total, err := strconv.ParseBool(s)
+		if err != nil {
+			return p, &ParamError{"total", "must be true or false"}
+		}
+		p.total = total
+	}
+
+	for _, f := range spec.filters {
+		s := q.Get(f.param)
+		if s == "" {
+			continue
+		}
+		v, err := f.kind.parse(s)
+		if err != nil {
+			return p, &ParamError{f.param, err.Error()}
+		}
+		p.filters = append(p.filters, appliedFilter{cond: f.cond, value: v})
+	}
+
+	return p, nil
+}
+
+// list runs one page of the query on q. where is an optional fixed
+// condition (such as the parent id) whose bind parameters are in args.
+func (spec ListSpec[T]) list(ctx context.Context, q queryer, p ListParams, where string, args ...any) (Page[T], error) {
+	var conds []string
+	if where != "" {
+		conds = append(conds, where)
+	}
+	for _, f := range p.filters {
+		args = append(args, f.value)
+		conds = append(conds, fmt.Sprintf(f.cond, fmt.Sprintf("$%d", len(args))))
+	}
+
+	result := Page[T]{Items: []T{}}
+
+	if p.total {
+		query := "SELECT count(*) FROM " + spec.table + whereClause(conds)
+		var total int
+		if err := q.QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+			return result, err
+		}
+		result.Total = &total
+	}
+
+	field := spec.sorts[p.sort]
+	op, dir := ">", "ASC"
+	if p.desc {
+		op, dir = "<", "DESC"
+	}
+	if p.cursor != nil {
+		args = append(args, p.cursor.Value, p.cursor.ID)
+		conds = append(conds, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)",
+			field.column, op, len(args)-1, field.sqlType, len(args)))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s %s, id %s LIMIT %d",
+		spec.columns, spec.table, whereClause(conds), field.column, dir, dir, p.limit+1)
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := spec.scan(rows)
+		if err != nil {
+			return result, err
+		}
+		result.Items = append(result.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	if len(result.Items) > p.limit {
+		result.Items = result.Items[:p.limit]
+		last := result.Items[p.limit-1]
+		sortKey := p.sort
+		if p.desc {
+			sortKey = "-" + sortKey
+		}
+		next := encodeCursor(pageCursor{Sort: sortKey, Value: field.value(last), ID: spec.id(last)})
+		result.NextCursor = &next
+	}
+	return result, nil
+}
+
+// ParamDoc describes a list parameter for API documentation.
+type ParamDoc struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// Params describes the list parameters the spec accepts.
+func (spec ListSpec[T]) Params() []ParamDoc {
+	params := []ParamDoc{
+		{Name: "limit", Type: "integer", Description: fmt.Sprintf("Page size, 1 to %d (default %d)", MaxPageLimit, DefaultPageLimit)},
+		{Name: "cursor", Type: "string", Description: "The next_cursor of the previous page"},
+		{Name: "sort", Type: "string", Description: "One of " + strings.Join(spec.sortKeys(), ", ") + "; prefix with - for descending (default " + spec.defaultSort + ")"},
+		{Name: "total", Type: "boolean", Description: "Include the total number of matching rows"},
+	}
+	for _, f := range spec.filters {
+		params = append(params, ParamDoc{Name: f.param, Type: f.kind.docType(), Description: f.description})
+	}
+	return params
+}
+
+func (spec ListSpec[T]) sortKeys() []string {
+	keys := make([]string, 0, len(spec.sorts))
+	for k := range spec.sorts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valid reports whether a cursor value can be cast to the column's type,
+// so a tampered cursor is a bad parameter rather than a failed query.
+func (f sortField[T]) valid(v string) bool {
+	switch f.sqlType {
+	case "integer":
+		_, err := strconv.Atoi(v)
+		return err == nil
+	case "double precision":
+		_, err := strconv.ParseFloat(v, 64)
+		return err == nil
+	case "date":
+		return ValidDate(v)
+	case "timestamp":
+		_, err := time.Parse(timestampLayout, v)
+		return err == nil
+	default:
+		return utf8.ValidString(v) && !strings.ContainsRune(v, 0)
+	}
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+func encodeCursor(c pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c pageCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (k filterKind) parse(s string) (any, error) {
+	switch k {
+	case dateFilter:
+		if !ValidDate(s) {
+			return nil, fmt.Errorf("must be a date in YYYY-MM-DD format")
+		}
+		return s, nil
+	case intFilter:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return n, nil
+	case floatFilter:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		return f, nil
+	case tagFilter:
+		var names []string
+		for _, name := range strings.Split(s, ",") {
+			if name = normalizeTag(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("must name at least one tag")
+		}
+		return pq.Array(names), nil
+	default:
+		// Escape LIKE wildcards so the text is matched literally.
+		return likeEscaper.Replace(s), nil
+	}
+}
+
+func (k filterKind) docType() string {
+	switch k {
+	case intFilter:
+		return "integer"
+	case floatFilter:
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+func nameContains(column string) filterField {
+	return filterField{
+		param:       "name",
+		cond:        column + ` ILIKE '%%' || %s || '%%'`,
+		kind:        containsFilter,
+		description: "Only rows whose name contains this text, ignoring case",
+	}
+}
+
+// timestampLayout is how a TIMESTAMP column is written in a cursor.
+const timestampLayout = "2006-01-02 15:04:05.999999"
+
+// timestampValue formats a TIMESTAMP column for a cursor.
+func timestampValue(t time.Time) string {
+	return t.Format(timestampLayout)
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseRejectsBadParams(t *testing.T) {
+	for query, param := range map[string]string{
+		"limit=0":                 "limit",
+		"limit=501":               "limit",
+		"limit=ten":               "limit",
+		"sort=calories":           "sort",
+		"sort=--time":             "sort",
+		"total=maybe":             "total",
+		"cursor=%25%25":           "cursor",
+		"cursor=bm90IGpzb24":      "cursor",
+		"from=2024-02-30":         "from",
+		"min_duration=1.5":        "min_duration",
+		"tag=,":                   "tag",
+		"name=bench&max_duration": "", // empty values are ignored
+	} {
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = WorkoutList.Parse(q)
+		var perr *ParamError
+		if param == "" {
+			if err != nil {
+				t.Errorf("%q: %v", query, err)
+			}
+		} else if !errors.As(err, &perr) || perr.Param != param {
+			t.Errorf("%q: err %v, want a ParamError for %s", query, err, param)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := pageCursor{Sort: "-time", Value: timestampValue(time.Date(2024, 3, 4, 18, 30, 0, 500, time.UTC)), ID: 12}
+	got, err := decodeCursor(encodeCursor(c))
+	if err != nil || *got != c {
+		t.Fatalf("decodeCursor(encodeCursor(%v)) = %v, %v", c, got, err)
+	}
+
+	p, err := WorkoutList.Parse(url.Values{"sort": {"-time"}, "cursor": {encodeCursor(c)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.desc || p.sort != "time" || p.cursor == nil || *p.cursor != c {
+		t.Errorf("parsed %+v, want a descending time page after %v", p, c)
+	}
+}
+
+func TestCursorMustMatchSort(t *testing.T) {
+	cursor := encodeCursor(pageCursor{Sort: "name", Value: "Push", ID: 3})
+	_, err := WorkoutList.Parse(url.Values{"sort": {"-name"}, "cursor": {cursor}})
+	var perr *ParamError
+	if !errors.As(err, &perr) || perr.Param != "cursor" {
+		t.Errorf("err %v, want a ParamError for cursor issued for another sort", err)
+	}
+}
+
+func TestCursorValueMustFitColumn(t *testing.T) {
+	for sort, value := range map[string]string{
+		"id":       "x",
+		"duration": "1e3",
+		"time":     "yesterday",
+		"name":     "\x00",
+	} {
+		cursor := encodeCursor(pageCursor{Sort: sort, Value: value, ID: 1})
+		_, err := WorkoutList.Parse(url.Values{"sort": {sort}, "cursor": {cursor}})
+		var perr *ParamError
+		if !errors.As(err, &perr) || perr.Param != "cursor" {
+			t.Errorf("%s cursor %q: err %v, want a ParamError for cursor", sort, value, err)
+		}
+	}
+
+	cursor := encodeCursor(pageCursor{Sort: "start_date", Value: "2024-13-01", ID: 1})
+	if _, err := WeekList.Parse(url.Values{"sort": {"start_date"}, "cursor": {cursor}}); err == nil {
+		t.Error("a week cursor with an impossible date was accepted")
+	}
+}
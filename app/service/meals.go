@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+var MealList = ListSpec[Meal]{
+	table:   "meals",
+	columns: mealColumns,
+	scan:    scanMeal,
+	id:      func(m Meal) int { return m.ID },
+	sorts: map[string]sortField[Meal]{
+		"name":     {"name", "text", func(m Meal) string { return m.Name }},
+		"calories": {"COALESCE(calories, 0)", "integer", func(m Meal) string { return strconv.Itoa(m.Calories) }},
+		"id":       {"id", "integer", func(m Meal) string { return strconv.Itoa(m.ID) }},
+	},
+	defaultSort: "id",
+	filters: []filterField{
+		nameContains("name"),
+		{"min_calories", "COALESCE(calories, 0) >= %s", intFilter, "Minimum calories"},
+		{"max_calories", "COALESCE(calories, 0) <= %s", intFilter, "Maximum calories"},
+	},
+}
+
+const mealColumns = "id, day_id, name, COALESCE(calories, 0)"
+
+func (s *Service) GetMeal(ctx context.Context, id int) (Meal, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+mealColumns+" FROM meals WHERE id = $1", id)
+	return scanMeal(row)
+}
+
+// ListMeals lists the meals of a day, or returns ErrNotFound if the day
+// does not exist.
+func (s *Service) ListMeals(ctx context.Context, dayID int, p ListParams) (Page[Meal], error) {
+	if err := s.exists(ctx, "days", dayID); err != nil {
+		return Page[Meal]{}, err
+	}
+	return MealList.list(ctx, s.db, p, "day_id = $1", dayID)
+}
+
+// CreateMeal adds a meal to a day, or returns ErrNotFound if the day does
+// not exist.
+func (s *Service) CreateMeal(ctx context.Context, dayID int, in MealInput) (Meal, error) {
+	if err := in.Validate(); err != nil {
+		return Meal{}, err
+	}
+	weekID, err := dayWeek(ctx, s.db, dayID)
+	if err != nil {
+		return Meal{}, err
+	}
+	meal, err := insertMeal(ctx, s.db, dayID, in)
+	if err != nil {
+		return Meal{}, err
+	}
+	s.publish(Event{Type: MealCreated, ID: meal.ID, WeekID: weekID, DayID: dayID})
+	return meal, nil
+}
+
+// LogMeal adds a meal on date, finding or creating its week and day (see
+// ResolveDay).
+func (s *Service) LogMeal(ctx context.Context, date time.Time, in MealInput) (Meal, error) {
+	if err := in.Validate(); err != nil {
+		return Meal{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Meal{}, err
+	}
+	defer tx.Rollback()
+
+	day, events, err := s.resolveDayTx(ctx, tx, date)
+	if err != nil {
+		return Meal{}, err
+	}
+	meal, err := insertMeal(ctx, tx, day.ID, in)
+	if err != nil {
+		return Meal{}, err
+	}
+	events = append(events, Event{Type: MealCreated, ID: meal.ID, WeekID: day.WeekID, DayID: day.ID})
+	return meal, s.commit(tx, events)
+}
+
+func insertMeal(ctx context.Context, q queryer, dayID int, in MealInput) (Meal, error) {
+	row := q.QueryRowContext(ctx,
+		"INSERT INTO meals (day_id, name, calories) VALUES ($1, $2, $3) RETURNING "+mealColumns,
+		dayID, in.Name, in.Calories,
+	)
+	return scanMeal(row)
+}
+
+func (s *Service) DeleteMeal(ctx context.Context, id int) error {
+	return s.deleteByID(ctx, "meals", id)
+}
+
+func scanMeal(s scanner) (Meal, error) {
+	var meal Meal
+	if err := s.Scan(&meal.ID, &meal.DayID, &meal.Name, &meal.Calories); err != nil {
+		return Meal{}, notFound(err)
+	}
+	return meal, nil
+}
@@ -0,0 +1,132 @@
+package service
+
+import "time"
+
+type Week struct {
+	ID        int    `json:"id"`
+	StartDate string `json:"start_date" format:"date"`
+}
+
+type Day struct {
+	ID      int    `json:"id"`
+	WeekID  int    `json:"week_id"`
+	DayDate string `json:"day_date" format:"date"`
+}
+
+type Workout struct {
+	ID       int       `json:"id"`
+	DayID    int       `json:"day_id"`
+	Name     string    `json:"name"`
+	Duration int       `json:"duration"`
+	Time     time.Time `json:"time"`
+}
+
+type Lift struct {
+	ID        int     `json:"id"`
+	WorkoutID int     `json:"workout_id"`
+	Name      string  `json:"name"`
+	Weight    float64 `json:"weight"`
+	Reps      int     `json:"reps"`
+	LiftOrder int     `json:"lift_order"`
+	RestTime  int     `json:"rest_time"`
+	BPM       int     `json:"bpm"`
+}
+
+// LiftSet is one set of a lift. LoggedAt is set by the server.
+type LiftSet struct {
+	ID        int       `json:"id"`
+	LiftID    int       `json:"lift_id"`
+	SetNumber int       `json:"set_number"`
+	Weight    float64   `json:"weight"`
+	Reps      int       `json:"reps"`
+	LoggedAt  time.Time `json:"logged_at"`
+}
+
+type Meal struct {
+	ID       int    `json:"id"`
+	DayID    int    `json:"day_id"`
+	Name     string `json:"name"`
+	Calories int    `json:"calories"`
+}
+
+// The inputs below are what a client supplies to create a row. Parent ids
+// are passed separately.
+
+type WeekInput struct {
+	StartDate string `json:"start_date" format:"date"`
+}
+
+type DayInput struct {
+	DayDate string `json:"day_date" format:"date"`
+}
+
+type WorkoutInput struct {
+	Name     string `json:"name"`
+	Duration int    `json:"duration"`
+}
+
+type LiftInput struct {
+	Name      string  `json:"name"`
+	Weight    float64 `json:"weight"`
+	Reps      int     `json:"reps"`
+	LiftOrder int     `json:"lift_order"`
+	RestTime  int     `json:"rest_time"`
+	BPM       int     `json:"bpm"`
+}
+
+type MealInput struct {
+	Name     string `json:"name"`
+	Calories int    `json:"calories"`
+}
+
+// Validate reports every invalid field of the input. Check adds the same
+// errors to v, so callers can combine them with checks of their own.
+
+func (in *WeekInput) Validate() error {
+	var v Validator
+	v.Date("start_date", in.StartDate)
+	return v.Err()
+}
+
+func (in *DayInput) Validate() error {
+	var v Validator
+	v.Date("day_date", in.DayDate)
+	return v.Err()
+}
+
+func (in *WorkoutInput) Validate() error {
+	var v Validator
+	in.Check(&v)
+	return v.Err()
+}
+
+func (in *WorkoutInput) Check(v *Validator) {
+	v.Required("name", in.Name)
+	v.Positive("duration", float64(in.Duration))
+}
+
+func (in *LiftInput) Validate() error {
+	var v Validator
+	in.Check(&v)
+	return v.Err()
+}
+
+func (in *LiftInput) Check(v *Validator) {
+	v.Required("name", in.Name)
+	v.NonNegative("weight", in.Weight) // 0 for bodyweight lifts, as for sets
+	v.Positive("reps", float64(in.Reps))
+	v.Positive("lift_order", float64(in.LiftOrder))
+	v.NonNegative("rest_time", float64(in.RestTime))
+	v.NonNegative("bpm", float64(in.BPM))
+}
+
+func (in *MealInput) Validate() error {
+	var v Validator
+	in.Check(&v)
+	return v.Err()
+}
+
+func (in *MealInput) Check(v *Validator) {
+	v.Required("name", in.Name)
+	v.NonNegative("calories", float64(in.Calories))
+}
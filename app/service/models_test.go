@@ -0,0 +1,20 @@
+package service
+
+import "testing"
+
+// A bodyweight lift must be accepted wherever its sets are.
+func TestBodyweightLifts(t *testing.T) {
+	lift := LiftInput{Name: "Pull-up", Weight: 0, Reps: 8, LiftOrder: 1}
+	if err := lift.Validate(); err != nil {
+		t.Errorf("lift: %v", err)
+	}
+	set := SessionSetInput{LiftID: 1, Weight: 0, Reps: 8}
+	if err := set.Validate(); err != nil {
+		t.Errorf("session set: %v", err)
+	}
+
+	lift.Weight, set.Weight = -5, -5
+	if lift.Validate() == nil || set.Validate() == nil {
+		t.Error("a negative weight was accepted")
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// CalendarViolations describes every row that breaks the calendar
+// invariants, one line each.
+func (s *Service) CalendarViolations(ctx context.Context) ([]string, error) {
+	checks := []struct {
+		query  string
+		format string
+	}{
+		{`SELECT a.id, a.start_date, b.id, b.start_date FROM weeks a
+          JOIN weeks b ON a.id < b.id
+              AND daterange(a.start_date, a.start_date + 7) && daterange(b.start_date, b.start_date + 7)
+          ORDER BY a.start_date, a.id`,
+			"week %d (%s) overlaps week %d (%s)"},
+		{`SELECT d.id, d.day_date, w.id, w.start_date FROM days d
+          JOIN weeks w ON w.id = d.week_id
+          WHERE d.day_date NOT BETWEEN w.start_date AND w.start_date + 6
+          ORDER BY d.day_date, d.id`,
+			"day %d (%s) is outside week %d (%s)"},
+		{`SELECT a.id, a.day_date, b.id, b.day_date FROM days a
+          JOIN days b ON a.id < b.id AND a.day_date = b.day_date
+          ORDER BY a.day_date, a.id`,
+			"day %d (%s) has the same date as day %d (%s)"},
+	}
+
+	var violations []string
+	for _, c := range checks {
+		found, err := queryAll(ctx, s.db, func(row scanner) (string, error) {
+			var id1, id2 int
+			var date1, date2 time.Time
+			if err := row.Scan(&id1, &date1, &id2, &date2); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf(c.format, id1, date1.Format(DateLayout), id2, date2.Format(DateLayout)), nil
+		}, c.query)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, found...)
+	}
+	return violations, nil
+}
+
+// RepairCalendar fixes every calendar violation in one transaction and
+// reports each change it makes:
+//
+//   - of overlapping weeks the earliest is kept and the others are removed
+//     once their days have moved;
+//   - every day moves to the kept week containing its date, creating one
+//     when there is none;
+//   - of several days on one date the first is kept, and the workouts and
+//     meals of the others move to it.
+func (s *Service) RepairCalendar(ctx context.Context, report func(change string)) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockCalendar(ctx, tx); err != nil {
+		return err
+	}
+
+	weeks, err := queryAll(ctx, tx, scanRepairWeek, "SELECT id, start_date FROM weeks ORDER BY start_date, id")
+	if err != nil {
+		return err
+	}
+	var kept, dropped []repairWeek
+	for _, w := range weeks {
+		if len(kept) > 0 && w.start.Before(kept[len(kept)-1].start.AddDate(0, 0, 7)) {
+			dropped = append(dropped, w)
+			continue
+		}
+		kept = append(kept, w)
+	}
+
+	days, err := queryAll(ctx, tx, scanRepairDay, "SELECT id, week_id, day_date FROM days ORDER BY day_date, id")
+	if err != nil {
+		return err
+	}
+	keptDay := map[string]int{}
+	for _, d := range days {
+		date := d.date.Format(DateLayout)
+		if first, ok := keptDay[date]; ok {
+			if err := mergeDay(ctx, tx, d.id, first); err != nil {
+				return err
+			}
+			report(fmt.Sprintf("merged day %d into day %d (%s)", d.id, first, date))
+			continue
+		}
+		keptDay[date] = d.id
+
+		i := sort.Search(len(kept), func(i int) bool { return kept[i].start.After(d.date) })
+		var week repairWeek
+		if i > 0 && !d.date.After(kept[i-1].start.AddDate(0, 0, 6)) {
+			week = kept[i-1]
+		} else {
+			var next time.Time
+			if i < len(kept) {
+				next = kept[i].start
+			}
+			start := s.newWeekStart(d.date, next)
+			if i > 0 && !start.After(kept[i-1].start.AddDate(0, 0, 6)) {
+				return fmt.Errorf("day %d (%s) does not fit between the weeks around it", d.id, date)
+			}
+			week.start = start
+			row := tx.QueryRowContext(ctx, "INSERT INTO weeks (start_date) VALUES ($1) RETURNING id", start.Format(DateLayout))
+			if err := row.Scan(&week.id); err != nil {
+				return err
+			}
+			kept = append(kept[:i], append([]repairWeek{week}, kept[i:]...)...)
+			report(fmt.Sprintf("created week %d (%s)", week.id, start.Format(DateLayout)))
+		}
+
+		if week.id != d.weekID {
+			if _, err := tx.ExecContext(ctx, "UPDATE days SET week_id = $1 WHERE id = $2", week.id, d.id); err != nil {
+				return err
+			}
+			report(fmt.Sprintf("moved day %d (%s) from week %d to week %d", d.id, date, d.weekID, week.id))
+		}
+	}
+
+	for _, w := range dropped {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM weeks WHERE id = $1", w.id); err != nil {
+			return err
+		}
+		report(fmt.Sprintf("removed week %d (%s)", w.id, w.start.Format(DateLayout)))
+	}
+	return tx.Commit()
+}
+
+type repairWeek struct {
+	id    int
+	start time.Time
+}
+
+type repairDay struct {
+	id     int
+	weekID int
+	date   time.Time
+}
+
+func scanRepairWeek(s scanner) (repairWeek, error) {
+	var w repairWeek
+	err := s.Scan(&w.id, &w.start)
+	return w, err
+}
+
+func scanRepairDay(s scanner) (repairDay, error) {
+	var d repairDay
+	err := s.Scan(&d.id, &d.weekID, &d.date)
+	return d, err
+}
+
+// mergeDay moves everything logged on day from onto day into, then
+// deletes from.
+func mergeDay(ctx context.Context, tx *sql.Tx, from, into int) error {
+	for _, table := range []string{"workouts", "meals"} {
+		if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET day_id = $1 WHERE day_id = $2", into, from); err != nil {
+			return err
+		}
+	}
+	_, err := tx.ExecContext(ctx, "DELETE FROM days WHERE id = $1", from)
+	return err
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Search result types.
+const (
+	ResultWorkout = "workout"
+	ResultLift    = "lift"
+	ResultMeal    = "meal"
+)
+
+// MaxSearchLimit is the most results Search returns of each type.
+const MaxSearchLimit = 50
+
+// SearchResult is a workout, lift or meal whose name matched a search,
+// with the day it is on. WorkoutID and WorkoutName are set for lifts.
+type SearchResult struct {
+	Type        string  `json:"type"`
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Rank        float64 `json:"rank"`
+	DayID       int     `json:"day_id"`
+	DayDate     string  `json:"day_date" format:"date"`
+	WeekID      int     `json:"week_id"`
+	WorkoutID   int     `json:"workout_id,omitempty"`
+	WorkoutName string  `json:"workout_name,omitempty"`
+}
+
+// SearchResults are the matches of a search grouped by type, best first.
+type SearchResults struct {
+	Query    string         `json:"query"`
+	Workouts []SearchResult `json:"workouts"`
+	Lifts    []SearchResult `json:"lifts"`
+	Meals    []SearchResult `json:"meals"`
+}
+
+// searchQuery turns what a user typed into a tsquery matching names that
+// contain every word, the last ones as typed so far: "bench pr" becomes
+// "bench:* & pr:*". Anything but letters and digits separates words, so
+// the input cannot inject tsquery operators. It returns "" if there are no
+// words.
+func searchQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// Search finds the workouts, lifts and meals whose names match q, up to
+// limit of each, ranked by ts_rank and then newest day first. Names are
+// matched with English stemming, so "squats" finds "Squat", using the
+// full-text indexes on each table's name.
+func (s *Service) Search(ctx context.Context, q string, limit int) (SearchResults, error) {
+	results := SearchResults{Query: q, Workouts: []SearchResult{}, Lifts: []SearchResult{}, Meals: []SearchResult{}}
+	if strings.TrimSpace(q) == "" {
+		return results, &ParamError{Param: "q", Message: "is required"}
+	}
+	if limit < 1 || limit > MaxSearchLimit {
+		return results, &ParamError{Param: "limit", Message: "must be between 1 and " + strconv.Itoa(MaxSearchLimit)}
+	}
+	tsquery := searchQuery(q)
+	if tsquery == "" {
+		return results, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+        WITH q AS (SELECT to_tsquery('english', $1) AS query),
+        hits AS (
+            SELECT 'workout' AS type, w.id, w.name, ts_rank(to_tsvector('english', w.name), q.query) AS rank,
+                   w.day_id, 0 AS workout_id, '' AS workout_name
+            FROM workouts w CROSS JOIN q
+            WHERE to_tsvector('english', w.name) @@ q.query
+            UNION ALL
+            SELECT 'lift', l.id, l.name, ts_rank(to_tsvector('english', l.name), q.query),
+                   w.day_id, w.id, w.name
+            FROM lifts l JOIN workouts w ON w.id = l.workout_id CROSS JOIN q
+            WHERE to_tsvector('english', l.name) @@ q.query
+            UNION ALL
+            SELECT 'meal', m.id, m.name, ts_rank(to_tsvector('english', m.name), q.query),
+                   m.day_id, 0, ''
+            FROM meals m CROSS JOIN q
+            WHERE to_tsvector('english', m.name) @@ q.query
+        ),
+        ranked AS (
+            SELECT h.*, d.day_date, d.week_id,
+                   row_number() OVER (PARTITION BY h.type ORDER BY h.rank DESC, d.day_date DESC, h.id DESC) AS n
+            FROM hits h JOIN days d ON d.id = h.day_id
+        )
+        SELECT type, id, name, rank, day_id, day_date, week_id, workout_id, workout_name
+        FROM ranked WHERE n <= $2
+        ORDER BY type, n`, tsquery, limit)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r SearchResult
+		var dayDate time.Time
+		if err := rows.Scan(&r.Type, &r.ID, &r.Name, &r.Rank, &r.DayID, &dayDate, &r.WeekID, &r.WorkoutID, &r.WorkoutName); err != nil {
+			return results, err
+		}
+		r.DayDate = dayDate.Format(DateLayout)
+		switch r.Type {
+		case ResultWorkout:
+			results.Workouts = append(results.Workouts, r)
+		case ResultLift:
+			results.Lifts = append(results.Lifts, r)
+		case ResultMeal:
+			results.Meals = append(results.Meals, r)
+		}
+	}
+	return results, rows.Err()
+}
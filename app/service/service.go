@@ -0,0 +1,117 @@
+// Package service holds the workout tracker's domain logic: the weeks,
+// days, workouts, lifts and meals, the rules they follow, and how they are
+// stored. The JSON API, the GraphQL endpoint and the HTML pages all call it
+// in-process, so every entry point validates and stores data the same way.
+//
+// Methods validate their input and report problems with *ValidationError,
+// *ConflictError, *ParamError or ErrNotFound; any other error comes from
+// the database.
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when the requested row, or the parent a new row
+// would be added to, does not exist.
+var ErrNotFound = errors.New("not found")
+
+// DateLayout is the format of every date the service accepts and returns.
+const DateLayout = "2006-01-02"
+
+// Service provides the domain operations over a database.
+type Service struct {
+	db        *sql.DB
+	weekStart time.Weekday
+	listeners listeners
+}
+
+// New returns a Service using db. Weeks the service creates on its own,
+// such as when logging by date, start on weekStart.
+func New(db *sql.DB, weekStart time.Weekday) *Service {
+	return &Service{db: db, weekStart: weekStart}
+}
+
+// WeekStart is the weekday new weeks start on.
+func (s *Service) WeekStart() time.Weekday {
+	return s.weekStart
+}
+
+// ValidDate reports whether v is a date in DateLayout.
+func ValidDate(v string) bool {
+	_, err := time.Parse(DateLayout, v)
+	return err == nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound and passes other errors through.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// exists reports ErrNotFound unless table has a row with id. The table name
+// is always a constant from this package, never user input.
+func (s *Service) exists(ctx context.Context, table string, id int) error {
+	var found bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+table+" WHERE id = $1)", id).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deleteByID removes a single row by primary key. The table name is always
+// a constant from this package, never user input.
+func (s *Service) deleteByID(ctx context.Context, table string, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM "+table+" WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// queryAll runs query and scans every row with scan.
+func queryAll[T any](ctx context.Context, q queryer, scan func(scanner) (T, error), query string, args ...any) ([]T, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []T{}
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
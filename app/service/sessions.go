@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// Session is a workout logged live: it is started on a day, its sets are
+// logged as they happen with the server's time, and finishing it sets the
+// workout's time to when it started and its duration to how long it took.
+// Until then the workout's duration is 0.
+type Session struct {
+	Workout
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Rest       *Rest      `json:"rest"`
+	Lifts      []LiftTree `json:"lifts"`
+}
+
+// Rest is the countdown started by logging a set, lasting its lift's
+// rest_time. Seconds is how much of it was left when the session was read;
+// a session has no Rest once it is over.
+type Rest struct {
+	LiftID  int       `json:"lift_id"`
+	Until   time.Time `json:"until"`
+	Seconds int       `json:"seconds"`
+}
+
+type SessionInput struct {
+	Name string `json:"name"`
+}
+
+// SessionSetInput is a set logged in a session, of one of the session's
+// lifts.
+type SessionSetInput struct {
+	LiftID int     `json:"lift_id"`
+	Weight float64 `json:"weight"`
+	Reps   int     `json:"reps"`
+}
+
+func (in *SessionInput) Validate() error {
+	var v Validator
+	v.Required("name", in.Name)
+	return v.Err()
+}
+
+func (in *SessionSetInput) Validate() error {
+	var v Validator
+	v.Positive("lift_id", float64(in.LiftID))
+	v.NonNegative("weight", in.Weight)
+	v.Positive("reps", float64(in.Reps))
+	return v.Err()
+}
+
+// errSessionFinished is returned for changes to a finished session.
+var errSessionFinished = &ConflictError{Fields: []FieldError{{Field: "session", Code: "finished", Message: "is already finished"}}}
+
+// StartSession adds a workout to a day and starts its session now, or
+// returns ErrNotFound if the day does not exist.
+func (s *Service) StartSession(ctx context.Context, dayID int, in SessionInput) (Session, error) {
+	if err := in.Validate(); err != nil {
+		return Session{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Session{}, err
+	}
+	defer tx.Rollback()
+
+	weekID, err := dayWeek(ctx, tx, dayID)
+	if err != nil {
+		return Session{}, err
+	}
+	session := Session{Lifts: []LiftTree{}}
+	row := tx.QueryRowContext(ctx,
+		"INSERT INTO workouts (day_id, name, duration) VALUES ($1, $2, 0) RETURNING "+workoutColumns,
+		dayID, in.Name,
+	)
+	if session.Workout, err = scanWorkout(row); err != nil {
+		return Session{}, err
+	}
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO workout_sessions (workout_id) VALUES ($1) RETURNING started_at", session.ID,
+	).Scan(&session.StartedAt)
+	if err != nil {
+		return Session{}, err
+	}
+	return session, s.commit(tx, []Event{{Type: WorkoutCreated, ID: session.ID, WeekID: weekID, DayID: dayID}})
+}
+
+// GetSession returns the session of a workout with its lifts and sets, or
+// ErrNotFound if the workout was not logged as a session.
+func (s *Service) GetSession(ctx context.Context, workoutID int) (Session, error) {
+	var session Session
+	var finishedAt, restUntil sql.NullTime
+	var restLiftID sql.NullInt64
+	var now time.Time
+	err := s.db.QueryRowContext(ctx, `
+        SELECT w.id, w.day_id, w.name, w.duration, w.time,
+               s.started_at, s.finished_at, s.rest_lift_id, s.rest_until, now()
+        FROM workout_sessions s JOIN workouts w ON w.id = s.workout_id
+        WHERE s.workout_id = $1`, workoutID,
+	).Scan(&session.ID, &session.DayID, &session.Name, &session.Duration, &session.Time,
+		&session.StartedAt, &finishedAt, &restLiftID, &restUntil, &now)
+	if err != nil {
+		return Session{}, notFound(err)
+	}
+	if finishedAt.Valid {
+		session.FinishedAt = &finishedAt.Time
+	}
+	if restUntil.Valid && restLiftID.Valid && restUntil.Time.After(now) {
+		session.Rest = &Rest{
+			LiftID:  int(restLiftID.Int64),
+			Until:   restUntil.Time,
+			Seconds: int(math.Ceil(restUntil.Time.Sub(now).Seconds())),
+		}
+	}
+
+	lifts, err := queryAll(ctx, s.db, scanLift,
+		"SELECT "+liftColumns+" FROM lifts WHERE workout_id = $1 ORDER BY lift_order, id", workoutID)
+	if err != nil {
+		return Session{}, err
+	}
+	sets, err := queryAll(ctx, s.db, scanLiftSet, `
+        SELECT id, lift_id, set_number, weight, reps, logged_at FROM lift_sets
+        WHERE lift_id IN (SELECT id FROM lifts WHERE workout_id = $1)
+        ORDER BY set_number`, workoutID)
+	setsByLift, err := groupBy(sets, err, func(set LiftSet) int { return set.LiftID })
+	if err != nil {
+		return Session{}, err
+	}
+	session.Lifts = make([]LiftTree, len(lifts))
+	for i, lift := range lifts {
+		session.Lifts[i] = LiftTree{Lift: lift, Sets: setsByLift[lift.ID]}
+		if session.Lifts[i].Sets == nil {
+			session.Lifts[i].Sets = []LiftSet{}
+		}
+	}
+	return session, nil
+}
+
+// LogSessionSet logs a set of one of the session's lifts, numbered after
+// the lift's last set and timed now, and starts the lift's rest. It
+// returns ErrNotFound if there is no such session, a ValidationError if the
+// lift is not in it, and a ConflictError if it is finished.
+func (s *Service) LogSessionSet(ctx context.Context, workoutID int, in SessionSetInput) (Session, error) {
+	if err := in.Validate(); err != nil {
+		return Session{}, err
+	}
+	dayID, weekID, err := s.workoutDay(ctx, workoutID)
+	if err != nil {
+		return Session{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Session{}, err
+	}
+	defer tx.Rollback()
+
+	// Locking the session numbers concurrent sets one after the other.
+	var finished bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT finished_at IS NOT NULL FROM workout_sessions WHERE workout_id = $1 FOR UPDATE", workoutID,
+	).Scan(&finished)
+	if err != nil {
+		return Session{}, notFound(err)
+	}
+	if finished {
+		return Session{}, errSessionFinished
+	}
+
+	var restTime int
+	err = tx.QueryRowContext(ctx,
+		"SELECT rest_time FROM lifts WHERE id = $1 AND workout_id = $2", in.LiftID, workoutID,
+	).Scan(&restTime)
+	if err == sql.ErrNoRows {
+		var v Validator
+		v.Add("lift_id", "not_found", "is not a lift in this session")
+		return Session{}, v.Err()
+	} else if err != nil {
+		return Session{}, err
+	}
+
+	set, err := scanLiftSet(tx.QueryRowContext(ctx, `
+        INSERT INTO lift_sets (lift_id, set_number, weight, reps)
+        SELECT $1, COALESCE(MAX(set_number), 0) + 1, $2, $3 FROM lift_sets WHERE lift_id = $1
+        RETURNING id, lift_id, set_number, weight, reps, logged_at`,
+		in.LiftID, in.Weight, in.Reps,
+	))
+	if err != nil {
+		return Session{}, err
+	}
+	_, err = tx.ExecContext(ctx, `
+        UPDATE workout_sessions SET rest_lift_id = $2, rest_until = now() + $3 * interval '1 second'
+        WHERE workout_id = $1`, workoutID, in.LiftID, restTime)
+	if err != nil {
+		return Session{}, err
+	}
+
+	if err := s.commit(tx, []Event{{Type: SetLogged, ID: set.ID, WeekID: weekID, DayID: dayID, WorkoutID: workoutID}}); err != nil {
+		return Session{}, err
+	}
+	return s.GetSession(ctx, workoutID)
+}
+
+// FinishSession ends a session now. The workout's time becomes the time
+// the session started and its duration the whole minutes it lasted, at
+// least one. It returns ErrNotFound if there is no such session and a
+// ConflictError if it is already finished.
+func (s *Service) FinishSession(ctx context.Context, workoutID int) (Session, error) {
+	e := Event{Type: SessionFinished, ID: workoutID, WorkoutID: workoutID}
+	err := s.db.QueryRowContext(ctx, `
+        WITH finished AS (
+            UPDATE workout_sessions SET finished_at = now(), rest_lift_id = NULL, rest_until = NULL
+            WHERE workout_id = $1 AND finished_at IS NULL
+            RETURNING workout_id, started_at, finished_at
+        ), updated AS (
+            UPDATE workouts w SET
+                time = f.started_at::timestamp,
+                duration = GREATEST(1, round(extract(epoch FROM f.finished_at - f.started_at) / 60))::integer
+            FROM finished f WHERE w.id = f.workout_id
+            RETURNING w.day_id
+        )
+        SELECT u.day_id, d.week_id FROM updated u JOIN days d ON d.id = u.day_id`, workoutID,
+	).Scan(&e.DayID, &e.WeekID)
+	if err == sql.ErrNoRows {
+		var started bool
+		err = s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM workout_sessions WHERE workout_id = $1)", workoutID).Scan(&started)
+		if err == nil && !started {
+			err = ErrNotFound
+		} else if err == nil {
+			err = errSessionFinished
+		}
+		return Session{}, err
+	} else if err != nil {
+		return Session{}, err
+	}
+	s.publish(e)
+	return s.GetSession(ctx, workoutID)
+}
+
+// OpenSessions returns the workouts on a day whose sessions are not
+// finished yet.
+func (s *Service) OpenSessions(ctx context.Context, dayID int) (map[int]bool, error) {
+	ids, err := queryAll(ctx, s.db, scanInt, `
+        SELECT s.workout_id FROM workout_sessions s JOIN workouts w ON w.id = s.workout_id
+        WHERE w.day_id = $1 AND s.finished_at IS NULL`, dayID)
+	if err != nil {
+		return nil, err
+	}
+	open := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		open[id] = true
+	}
+	return open, nil
+}
+
+func scanInt(s scanner) (int, error) {
+	var n int
+	err := s.Scan(&n)
+	return n, err
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// MaxTagLength is the longest tag name, in characters.
+const MaxTagLength = 40
+
+// Tag labels workouts with what kind of session they were, such as "push",
+// "legs" or "deload". A workout can have any number of tags. Names are
+// stored lowercase, so "Push" and "push" are the same tag.
+type Tag struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type TagInput struct {
+	Name string `json:"name"`
+}
+
+// WorkoutTagsInput replaces all of a workout's tags. Tags that do not
+// exist yet are created.
+type WorkoutTagsInput struct {
+	Tags []string `json:"tags"`
+}
+
+// TagTotal adds up the workouts of a week with one tag. Volume is the
+// weight times reps of their lifts, counting each logged set, or the lift
+// itself if it has none.
+type TagTotal struct {
+	TagID    int     `json:"tag_id"`
+	Tag      string  `json:"tag"`
+	Sessions int     `json:"sessions"`
+	Minutes  int     `json:"minutes"`
+	Volume   float64 `json:"volume"`
+}
+
+var TagList = ListSpec[Tag]{
+	table:   "tags",
+	columns: "id, name",
+	scan:    scanTag,
+	id:      func(t Tag) int { return t.ID },
+	sorts: map[string]sortField[Tag]{
+		"name": {"name", "text", func(t Tag) string { return t.Name }},
+		"id":   {"id", "integer", func(t Tag) string { return strconv.Itoa(t.ID) }},
+	},
+	defaultSort: "name",
+	filters: []filterField{
+		nameContains("name"),
+	},
+}
+
+// taggedWorkouts selects the ids of the workouts with any of the tag names
+// in the array bound to %s. The tag filters of the workout, day and week
+// lists are built on it.
+const taggedWorkouts = "SELECT wt.workout_id FROM workout_tags wt JOIN tags t ON t.id = wt.tag_id WHERE t.name = ANY(%s)"
+
+// normalizeTag is the stored form of a tag name.
+func normalizeTag(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// checkTag reports what is wrong with a normalized tag name. Commas are
+// not allowed because the tag filter takes a comma-separated list.
+func checkTag(v *Validator, field, name string) {
+	switch {
+	case name == "":
+		v.Add(field, "required", "is required")
+	case utf8.RuneCountInString(name) > MaxTagLength:
+		v.Add(field, "too_long", "must be at most "+strconv.Itoa(MaxTagLength)+" characters")
+	case strings.Contains(name, ","):
+		v.Add(field, "invalid_tag", "cannot contain a comma")
+	}
+}
+
+func (in *TagInput) Validate() error {
+	in.Name = normalizeTag(in.Name)
+	var v Validator
+	checkTag(&v, "name", in.Name)
+	return v.Err()
+}
+
+// Validate normalizes the names and drops repeats of the same tag.
+func (in *WorkoutTagsInput) Validate() error {
+	var v Validator
+	seen := map[string]bool{}
+	names := []string{}
+	for i, name := range in.Tags {
+		name = normalizeTag(name)
+		checkTag(&v, "tags["+strconv.Itoa(i)+"]", name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	in.Tags = names
+	return v.Err()
+}
+
+func (s *Service) ListTags(ctx context.Context, p ListParams) (Page[Tag], error) {
+	return TagList.list(ctx, s.db, p, "")
+}
+
+func (s *Service) GetTag(ctx context.Context, id int) (Tag, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT id, name FROM tags WHERE id = $1", id)
+	return scanTag(row)
+}
+
+func (s *Service) CreateTag(ctx context.Context, in TagInput) (Tag, error) {
+	if err := in.Validate(); err != nil {
+		return Tag{}, err
+	}
+	row := s.db.QueryRowContext(ctx, "INSERT INTO tags (name) VALUES ($1) RETURNING id, name", in.Name)
+	tag, err := scanTag(row)
+	return tag, tagError(err)
+}
+
+// RenameTag renames a tag on every workout that has it, or returns
+// ErrNotFound if the tag does not exist.
+func (s *Service) RenameTag(ctx context.Context, id int, in TagInput) (Tag, error) {
+	if err := in.Validate(); err != nil {
+		return Tag{}, err
+	}
+	row := s.db.QueryRowContext(ctx, "UPDATE tags SET name = $2 WHERE id = $1 RETURNING id, name", id, in.Name)
+	tag, err := scanTag(row)
+	return tag, tagError(err)
+}
+
+// DeleteTag deletes a tag, removing it from its workouts.
+func (s *Service) DeleteTag(ctx context.Context, id int) error {
+	return s.deleteByID(ctx, "tags", id)
+}
+
+// WorkoutTags lists a workout's tags by name, or returns ErrNotFound if
+// the workout does not exist.
+func (s *Service) WorkoutTags(ctx context.Context, workoutID int) ([]Tag, error) {
+	if err := s.exists(ctx, "workouts", workoutID); err != nil {
+		return nil, err
+	}
+	return queryAll(ctx, s.db, scanTag, `
+        SELECT t.id, t.name FROM tags t JOIN workout_tags wt ON wt.tag_id = t.id
+        WHERE wt.workout_id = $1 ORDER BY t.name`, workoutID)
+}
+
+// SetWorkoutTags replaces a workout's tags with the named ones, creating
+// any that do not exist, or returns ErrNotFound if the workout does not
+// exist.
+func (s *Service) SetWorkoutTags(ctx context.Context, workoutID int, in WorkoutTagsInput) ([]Tag, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the workout so a concurrent delete cannot leave tags behind.
+	var found bool
+	err = tx.QueryRowContext(ctx, "SELECT true FROM workouts WHERE id = $1 FOR UPDATE", workoutID).Scan(&found)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	names := pq.Array(in.Tags)
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING", names); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM workout_tags WHERE workout_id = $1", workoutID); err != nil {
+		return nil, err
+	}
+	tags, err := queryAll(ctx, tx, scanTag, `
+        WITH tagged AS (
+            INSERT INTO workout_tags (workout_id, tag_id)
+            SELECT $1, id FROM tags WHERE name = ANY($2)
+            RETURNING tag_id
+        )
+        SELECT t.id, t.name FROM tags t JOIN tagged ON tagged.tag_id = t.id ORDER BY t.name`, workoutID, names)
+	if err != nil {
+		return nil, err
+	}
+	return tags, tx.Commit()
+}
+
+// UntagWorkout removes one tag from a workout, or returns ErrNotFound if
+// the workout does not have it.
+func (s *Service) UntagWorkout(ctx context.Context, workoutID, tagID int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM workout_tags WHERE workout_id = $1 AND tag_id = $2", workoutID, tagID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// WeekTagTotals adds up the workouts of a week by tag, in tag name order,
+// or returns ErrNotFound if the week does not exist. A workout with two
+// tags counts towards both; untagged workouts are left out.
+func (s *Service) WeekTagTotals(ctx context.Context, weekID int) ([]TagTotal, error) {
+	if err := s.exists(ctx, "weeks", weekID); err != nil {
+		return nil, err
+	}
+	return queryAll(ctx, s.db, scanTagTotal, `
+        WITH week_workouts AS (
+            SELECT w.id, w.duration FROM workouts w JOIN days d ON d.id = w.day_id
+            WHERE d.week_id = $1
+        ),
+        week_lifts AS (
+            SELECT id, workout_id, weight, reps FROM lifts
+            WHERE workout_id IN (SELECT id FROM week_workouts)
+        ),
+        set_volume AS (
+            SELECT lift_id, SUM(weight * reps) AS volume FROM lift_sets
+            WHERE lift_id IN (SELECT id FROM week_lifts)
+            GROUP BY lift_id
+        ),
+        workout_volume AS (
+            SELECT l.workout_id, SUM(COALESCE(sv.volume, l.weight * l.reps)) AS volume
+            FROM week_lifts l LEFT JOIN set_volume sv ON sv.lift_id = l.id
+            GROUP BY l.workout_id
+        )
+        SELECT t.id, t.name, count(*), COALESCE(SUM(ww.duration), 0), COALESCE(SUM(wv.volume), 0)
+        FROM week_workouts ww
+        JOIN workout_tags wt ON wt.workout_id = ww.id
+        JOIN tags t ON t.id = wt.tag_id
+        LEFT JOIN workout_volume wv ON wv.workout_id = ww.id
+        GROUP BY t.id, t.name
+        ORDER BY t.name`, weekID)
+}
+
+// tagError maps a clash with another tag's name to a ConflictError.
+func tagError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Constraint == "tags_name_key" {
+		return &ConflictError{Fields: []FieldError{{Field: "name", Code: "duplicate", Message: "already names another tag"}}}
+	}
+	return err
+}
+
+func scanTag(s scanner) (Tag, error) {
+	var tag Tag
+	if err := s.Scan(&tag.ID, &tag.Name); err != nil {
+		return Tag{}, notFound(err)
+	}
+	return tag, nil
+}
+
+func scanTagTotal(s scanner) (TagTotal, error) {
+	var t TagTotal
+	err := s.Scan(&t.TagID, &t.Tag, &t.Sessions, &t.Minutes, &t.Volume)
+	return t, err
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// FieldError describes one invalid field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects the field errors of a request.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return joinFields(e.Fields)
+}
+
+// Extensions exposes the field errors to GraphQL clients.
+func (e *ValidationError) Extensions() map[string]any {
+	return map[string]any{"code": "validation_failed", "errors": e.Fields}
+}
+
+// ConflictError reports fields that clash with rows already stored, such
+// as a second day on the same date.
+type ConflictError struct {
+	Fields []FieldError
+}
+
+func (e *ConflictError) Error() string {
+	return joinFields(e.Fields)
+}
+
+// Extensions exposes the conflicting fields to GraphQL clients.
+func (e *ConflictError) Extensions() map[string]any {
+	return map[string]any{"code": "conflict", "errors": e.Fields}
+}
+
+// ParamError reports an invalid list parameter.
+type ParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	return e.Param + ": " + e.Message
+}
+
+func joinFields(fields []FieldError) string {
+	msgs := make([]string, len(fields))
+	for i, f := range fields {
+		msgs[i] = f.Field + " " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validator accumulates field errors so a client learns about every
+// invalid field at once.
+type Validator struct {
+	fields []FieldError
+}
+
+func (v *Validator) Add(field, code, message string) {
+	v.fields = append(v.fields, FieldError{Field: field, Code: code, Message: message})
+}
+
+func (v *Validator) Required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, "required", "is required")
+	}
+}
+
+func (v *Validator) Date(field, value string) {
+	if value == "" {
+		v.Add(field, "required", "is required")
+	} else if !ValidDate(value) {
+		v.Add(field, "invalid_date", "must be a date in YYYY-MM-DD format")
+	}
+}
+
+func (v *Validator) Positive(field string, n float64) {
+	if n <= 0 {
+		v.Add(field, "must_be_positive", "must be greater than zero")
+	}
+}
+
+func (v *Validator) NonNegative(field string, n float64) {
+	if n < 0 {
+		v.Add(field, "must_not_be_negative", "cannot be negative")
+	}
+}
+
+// ID parses a numeric id that may arrive as a JSON number or string.
+func (v *Validator) ID(field, value string) int {
+	n, err := strconv.Atoi(value)
+	if value == "" {
+		v.Add(field, "required", "is required")
+	} else if err != nil || n <= 0 {
+		v.Add(field, "invalid_id", "must be a positive integer")
+	}
+	return n
+}
+
+// Nested runs check against a fresh validator and records its errors
+// under prefix, so a field of a nested object is reported as, for example,
+// "lifts[0].reps".
+func (v *Validator) Nested(prefix string, check func(*Validator)) {
+	var sub Validator
+	check(&sub)
+	for _, f := range sub.fields {
+		f.Field = prefix + "." + f.Field
+		v.fields = append(v.fields, f)
+	}
+}
+
+// Err returns a *ValidationError if any check failed.
+func (v *Validator) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+// RequireParent reports a parent row referenced from a request body that
+// does not exist as an error on field, rather than as ErrNotFound for the
+// request itself.
+func RequireParent(field string, err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return &ValidationError{Fields: []FieldError{{Field: field, Code: "not_found", Message: "does not exist"}}}
+	}
+	return err
+}
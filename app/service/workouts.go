@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var WorkoutList = ListSpec[Workout]{
+	table:   "workouts",
+	columns: "id, day_id, name, duration, time",
+	scan:    scanWorkout,
+	id:      func(w Workout) int { return w.ID },
+	sorts: map[string]sortField[Workout]{
+		"time":     {"time", "timestamp", func(w Workout) string { return timestampValue(w.Time) }},
+		"name":     {"name", "text", func(w Workout) string { return w.Name }},
+		"duration": {"duration", "integer", func(w Workout) string { return strconv.Itoa(w.Duration) }},
+		"id":       {"id", "integer", func(w Workout) string { return strconv.Itoa(w.ID) }},
+	},
+	defaultSort: "time",
+	filters: []filterField{
+		{"from", "time >= %s::date", dateFilter, "Only workouts on or after this date"},
+		{"to", "time < %s::date + 1", dateFilter, "Only workouts on or before this date"},
+		nameContains("name"),
+		{"min_duration", "duration >= %s", intFilter, "Minimum duration in minutes"},
+		{"max_duration", "duration <= %s", intFilter, "Maximum duration in minutes"},
+		{"tag", "id IN (" + taggedWorkouts + ")", tagFilter, "Only workouts with any of these comma-separated tags"},
+	},
+}
+
+var LiftList = ListSpec[Lift]{
+	table:   "lifts",
+	columns: liftColumns,
+	scan:    scanLift,
+	id:      func(l Lift) int { return l.ID },
+	sorts: map[string]sortField[Lift]{
+		"lift_order": {"lift_order", "integer", func(l Lift) string { return strconv.Itoa(l.LiftOrder) }},
+		"name":       {"name", "text", func(l Lift) string { return l.Name }},
+		"weight":     {"weight", "double precision", func(l Lift) string { return strconv.FormatFloat(l.Weight, 'g', -1, 64) }},
+		"reps":       {"reps", "integer", func(l Lift) string { return strconv.Itoa(l.Reps) }},
+		"id":         {"id", "integer", func(l Lift) string { return strconv.Itoa(l.ID) }},
+	},
+	defaultSort: "lift_order",
+	filters: []filterField{
+		nameContains("name"),
+		{"min_weight", "weight >= %s", floatFilter, "Minimum weight"},
+		{"max_weight", "weight <= %s", floatFilter, "Maximum weight"},
+		{"min_reps", "reps >= %s", intFilter, "Minimum reps"},
+		{"max_reps", "reps <= %s", intFilter, "Maximum reps"},
+	},
+}
+
+const (
+	workoutColumns = "id, day_id, name, duration, time"
+	liftColumns    = "id, workout_id, name, weight, reps, lift_order, rest_time, COALESCE(bpm, 0)"
+)
+
+func (s *Service) GetWorkout(ctx context.Context, id int) (Workout, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+workoutColumns+" FROM workouts WHERE id = $1", id)
+	return scanWorkout(row)
+}
+
+// ListWorkouts lists the workouts of a day, or returns ErrNotFound if the
+// day does not exist.
+func (s *Service) ListWorkouts(ctx context.Context, dayID int, p ListParams) (Page[Workout], error) {
+	if err := s.exists(ctx, "days", dayID); err != nil {
+		return Page[Workout]{}, err
+	}
+	return WorkoutList.list(ctx, s.db, p, "day_id = $1", dayID)
+}
+
+// CreateWorkout adds a workout to a day, or returns ErrNotFound if the day
+// does not exist.
+func (s *Service) CreateWorkout(ctx context.Context, dayID int, in WorkoutInput) (Workout, error) {
+	if err := in.Validate(); err != nil {
+		return Workout{}, err
+	}
+	weekID, err := dayWeek(ctx, s.db, dayID)
+	if err != nil {
+		return Workout{}, err
+	}
+	row := s.db.QueryRowContext(ctx,
+		"INSERT INTO workouts (day_id, name, duration) VALUES ($1, $2, $3) RETURNING "+workoutColumns,
+		dayID, in.Name, in.Duration,
+	)
+	workout, err := scanWorkout(row)
+	if err != nil {
+		return Workout{}, err
+	}
+	s.publish(Event{Type: WorkoutCreated, ID: workout.ID, WeekID: weekID, DayID: dayID})
+	return workout, nil
+}
+
+// LogWorkout adds a workout on date, finding or creating its week and day
+// (see ResolveDay). The workout is timed at the current time of day on
+// date rather than now.
+func (s *Service) LogWorkout(ctx context.Context, date time.Time, in WorkoutInput) (Workout, error) {
+	if err := in.Validate(); err != nil {
+		return Workout{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Workout{}, err
+	}
+	defer tx.Rollback()
+
+	day, events, err := s.resolveDayTx(ctx, tx, date)
+	if err != nil {
+		return Workout{}, err
+	}
+	workout, err := scanWorkout(tx.QueryRowContext(ctx, `
+        INSERT INTO workouts (day_id, name, duration, time)
+        VALUES ($1, $2, $3, $4::date + LOCALTIME)
+        RETURNING `+workoutColumns,
+		day.ID, in.Name, in.Duration, day.DayDate,
+	))
+	if err != nil {
+		return Workout{}, err
+	}
+	events = append(events, Event{Type: WorkoutCreated, ID: workout.ID, WeekID: day.WeekID, DayID: day.ID})
+	return workout, s.commit(tx, events)
+}
+
+func (s *Service) DeleteWorkout(ctx context.Context, id int) error {
+	e := Event{Type: WorkoutDeleted, ID: id}
+	err := s.db.QueryRowContext(ctx, `
+        DELETE FROM workouts w USING days d
+        WHERE w.id = $1 AND d.id = w.day_id
+        RETURNING d.id, d.week_id`, id).Scan(&e.DayID, &e.WeekID)
+	if err != nil {
+		return notFound(err)
+	}
+	s.publish(e)
+	return nil
+}
+
+func (s *Service) GetLift(ctx context.Context, id int) (Lift, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+liftColumns+" FROM lifts WHERE id = $1", id)
+	return scanLift(row)
+}
+
+// ListLifts lists the lifts of a workout, or returns ErrNotFound if the
+// workout does not exist.
+func (s *Service) ListLifts(ctx context.Context, workoutID int, p ListParams) (Page[Lift], error) {
+	if err := s.exists(ctx, "workouts", workoutID); err != nil {
+		return Page[Lift]{}, err
+	}
+	return LiftList.list(ctx, s.db, p, "workout_id = $1", workoutID)
+}
+
+// CreateLift adds a lift to a workout, or returns ErrNotFound if the
+// workout does not exist.
+func (s *Service) CreateLift(ctx context.Context, workoutID int, in LiftInput) (Lift, error) {
+	if err := in.Validate(); err != nil {
+		return Lift{}, err
+	}
+	dayID, weekID, err := s.workoutDay(ctx, workoutID)
+	if err != nil {
+		return Lift{}, err
+	}
+	lift, err := insertLift(ctx, s.db, workoutID, in)
+	if err != nil {
+		return Lift{}, err
+	}
+	s.publish(Event{Type: LiftCreated, ID: lift.ID, WeekID: weekID, DayID: dayID, WorkoutID: workoutID})
+	return lift, nil
+}
+
+func insertLift(ctx context.Context, q queryer, workoutID int, in LiftInput) (Lift, error) {
+	row := q.QueryRowContext(ctx, `
+        INSERT INTO lifts (workout_id, name, weight, reps, lift_order, rest_time, bpm)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING `+liftColumns,
+		workoutID, in.Name, in.Weight, in.Reps, in.LiftOrder, in.RestTime, in.BPM,
+	)
+	return scanLift(row)
+}
+
+func (s *Service) DeleteLift(ctx context.Context, id int) error {
+	return s.deleteByID(ctx, "lifts", id)
+}
+
+// WorkoutDocument is a whole workout with its lifts and their sets,
+// created together by CreateWorkoutTree. The day is given either by DayID
+// or by Date, in which case it is found or created like ResolveDay does.
+type WorkoutDocument struct {
+	DayID    int            `json:"day_id,omitempty"`
+	Date     string         `json:"date,omitempty" format:"date"`
+	Name     string         `json:"name"`
+	Duration int            `json:"duration"`
+	Lifts    []LiftDocument `json:"lifts,omitempty"`
+}
+
+// LiftDocument is a lift of a WorkoutDocument. lift_order defaults to the
+// lift's position, and weight and reps, when both are left out, to the
+// heaviest set. Sets are numbered in the order given.
+type LiftDocument struct {
+	LiftInput
+	Sets []SetInput `json:"sets,omitempty"`
+}
+
+type SetInput struct {
+	Weight float64 `json:"weight"`
+	Reps   int     `json:"reps"`
+}
+
+// WorkoutTree is a created workout with its lifts and their sets.
+type WorkoutTree struct {
+	Workout
+	Lifts []LiftTree `json:"lifts"`
+}
+
+type LiftTree struct {
+	Lift
+	Sets []LiftSet `json:"sets"`
+}
+
+// Validate fills in the defaults described on LiftDocument and checks the
+// whole document, reporting nested fields as "lifts[1].sets[0].reps".
+func (doc *WorkoutDocument) Validate() error {
+	var v Validator
+	switch {
+	case doc.DayID == 0 && doc.Date == "":
+		v.Add("day_id", "required", "or date is required")
+	case doc.DayID != 0 && doc.Date != "":
+		v.Add("date", "conflicts", "cannot be given together with day_id")
+	case doc.DayID < 0:
+		v.Add("day_id", "invalid_id", "must be a positive integer")
+	case doc.Date != "":
+		v.Date("date", doc.Date)
+	}
+	(&WorkoutInput{Name: doc.Name, Duration: doc.Duration}).Check(&v)
+
+	for i := range doc.Lifts {
+		lift := &doc.Lifts[i]
+		if lift.LiftOrder == 0 {
+			lift.LiftOrder = i + 1
+		}
+		if lift.Weight == 0 && lift.Reps == 0 {
+			for _, set := range lift.Sets {
+				if set.Weight > lift.Weight || (set.Weight == lift.Weight && set.Reps > lift.Reps) {
+					lift.Weight, lift.Reps = set.Weight, set.Reps
+				}
+			}
+		}
+		v.Nested(fmt.Sprintf("lifts[%d]", i), func(v *Validator) {
+			lift.Check(v)
+			for j, set := range lift.Sets {
+				v.Nested(fmt.Sprintf("sets[%d]", j), func(v *Validator) {
+					v.NonNegative("weight", set.Weight)
+					v.Positive("reps", float64(set.Reps))
+				})
+			}
+		})
+	}
+	return v.Err()
+}
+
+// CreateWorkoutTree creates a workout with its lifts and sets in one
+// transaction, so a failure leaves nothing behind. It returns ErrNotFound
+// if doc.DayID does not exist.
+func (s *Service) CreateWorkoutTree(ctx context.Context, doc WorkoutDocument) (WorkoutTree, error) {
+	if err := doc.Validate(); err != nil {
+		return WorkoutTree{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return WorkoutTree{}, err
+	}
+	defer tx.Rollback()
+
+	var events []Event
+	dayID, weekID := doc.DayID, 0
+	if dayID == 0 {
+		date, _ := time.Parse(DateLayout, doc.Date)
+		var day Day
+		day, events, err = s.resolveDayTx(ctx, tx, date)
+		if err != nil {
+			return WorkoutTree{}, err
+		}
+		dayID, weekID = day.ID, day.WeekID
+	} else if weekID, err = dayWeek(ctx, tx, dayID); err != nil {
+		return WorkoutTree{}, err
+	}
+
+	var tree WorkoutTree
+	row := tx.QueryRowContext(ctx,
+		"INSERT INTO workouts (day_id, name, duration) VALUES ($1, $2, $3) RETURNING "+workoutColumns,
+		dayID, doc.Name, doc.Duration,
+	)
+	if tree.Workout, err = scanWorkout(row); err != nil {
+		return WorkoutTree{}, err
+	}
+	events = append(events, Event{Type: WorkoutCreated, ID: tree.ID, WeekID: weekID, DayID: dayID})
+
+	tree.Lifts = []LiftTree{}
+	for _, in := range doc.Lifts {
+		lift := LiftTree{Sets: []LiftSet{}}
+		if lift.Lift, err = insertLift(ctx, tx, tree.ID, in.LiftInput); err != nil {
+			return WorkoutTree{}, err
+		}
+		events = append(events, Event{Type: LiftCreated, ID: lift.ID, WeekID: weekID, DayID: dayID, WorkoutID: tree.ID})
+		for i, set := range in.Sets {
+			row := tx.QueryRowContext(ctx, `
+                INSERT INTO lift_sets (lift_id, set_number, weight, reps)
+                VALUES ($1, $2, $3, $4)
+                RETURNING id, lift_id, set_number, weight, reps, logged_at`,
+				lift.ID, i+1, set.Weight, set.Reps,
+			)
+			liftSet, err := scanLiftSet(row)
+			if err != nil {
+				return WorkoutTree{}, err
+			}
+			lift.Sets = append(lift.Sets, liftSet)
+			events = append(events, Event{Type: SetLogged, ID: liftSet.ID, WeekID: weekID, DayID: dayID, WorkoutID: tree.ID})
+		}
+		tree.Lifts = append(tree.Lifts, lift)
+	}
+
+	return tree, s.commit(tx, events)
+}
+
+func scanWorkout(s scanner) (Workout, error) {
+	var workout Workout
+	if err := s.Scan(&workout.ID, &workout.DayID, &workout.Name, &workout.Duration, &workout.Time); err != nil {
+		return Workout{}, notFound(err)
+	}
+	return workout, nil
+}
+
+func scanLift(s scanner) (Lift, error) {
+	var lift Lift
+	if err := s.Scan(&lift.ID, &lift.WorkoutID, &lift.Name, &lift.Weight, &lift.Reps, &lift.LiftOrder, &lift.RestTime, &lift.BPM); err != nil {
+		return Lift{}, notFound(err)
+	}
+	return lift, nil
+}
+
+func scanLiftSet(s scanner) (LiftSet, error) {
+	var set LiftSet
+	if err := s.Scan(&set.ID, &set.LiftID, &set.SetNumber, &set.Weight, &set.Reps, &set.LoggedAt); err != nil {
+		return LiftSet{}, notFound(err)
+	}
+	return set, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"lab8-go/service"
+)
+
+// addSessionRoutes registers live workout sessions: the JSON API to start
+// one, log its sets and finish it, and the page that does so from a phone
+// at the gym. A session is addressed by its workout's id.
+func addSessionRoutes() {
+	handleJSON("POST /api/v1/days/{id}/sessions", apiStartSession)
+	handleJSON("GET /api/v1/workouts/{id}/session", apiGetSession)
+	handleJSON("POST /api/v1/workouts/{id}/session/sets", apiLogSessionSet)
+	handleJSON("POST /api/v1/workouts/{id}/session/finish", apiFinishSession)
+	http.HandleFunc("GET /session", sessionPageHandler)
+}
+
+func sessionURL(workoutID int) string {
+	return fmt.Sprintf("/api/v1/workouts/%d/session", workoutID)
+}
+
+func apiStartSession(w http.ResponseWriter, r *http.Request) {
+	dayID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req service.SessionInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	session, err := svc.StartSession(r.Context(), dayID, req)
+	if err != nil {
+		writeErr(w, r, "starting session", err)
+		return
+	}
+	writeCreated(w, sessionURL(session.ID), session)
+}
+
+func apiGetSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	session, err := svc.GetSession(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, "fetching session", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func apiLogSessionSet(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req service.SessionSetInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	session, err := svc.LogSessionSet(r.Context(), id, req)
+	if err != nil {
+		writeErr(w, r, "logging set", err)
+		return
+	}
+	writeCreated(w, sessionURL(id), session)
+}
+
+func apiFinishSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	session, err := svc.FinishSession(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, "finishing session", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// sessionPage is the data of templates/session.html. The page draws the
+// session from its JSON, the same way it redraws it after every change.
+type sessionPage struct {
+	Session service.Session
+	WeekID  int
+}
+
+func sessionPageHandler(w http.ResponseWriter, r *http.Request) {
+	workoutID, ok := pageID(w, r, "workout_id")
+	if !ok {
+		return
+	}
+	session, err := svc.GetSession(r.Context(), workoutID)
+	if err != nil {
+		pageError(w, r, "fetching session", err)
+		return
+	}
+	day, err := svc.GetDay(r.Context(), session.DayID)
+	if err != nil {
+		pageError(w, r, "fetching day", err)
+		return
+	}
+	renderPage(w, r, "session", sessionPage{Session: session, WeekID: day.WeekID})
+}
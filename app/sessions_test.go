@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"lab8-go/service"
+)
+
+func TestSessionPageEmbedsSession(t *testing.T) {
+	session := service.Session{
+		Workout:   service.Workout{ID: 7, DayID: 3, Name: "Push </script>"},
+		StartedAt: time.Date(2024, 3, 4, 18, 0, 0, 0, time.UTC),
+		Rest:      &service.Rest{LiftID: 2, Seconds: 90},
+		Lifts:     []service.LiftTree{{Lift: service.Lift{ID: 2, WorkoutID: 7, Name: "Bench", RestTime: 90}, Sets: []service.LiftSet{}}},
+	}
+
+	body := renderTestPage(t, "session", sessionPage{Session: session, WeekID: 1})
+	for _, want := range []string{`"started_at":"2024-03-04T18:00:00Z"`, `"rest":{"lift_id":2,`, `'/api/v1/workouts/' +  7  + '/session'`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page is missing %s", want)
+		}
+	}
+	if strings.Contains(body, "Push </script>") {
+		t.Error("the workout name is not escaped inside the script")
+	}
+}
+
+func TestWorkoutsPageLinksOpenSessions(t *testing.T) {
+	body := renderTestPage(t, "workouts", workoutsPage{
+		DayID:        3,
+		Workouts:     []service.Workout{{ID: 7, Name: "Push"}, {ID: 8, Name: "Pull"}},
+		OpenSessions: map[int]bool{8: true},
+	})
+	if strings.Count(body, "Resume Session") != 1 || !strings.Contains(body, "/session?workout_id=8") {
+		t.Errorf("want one Resume Session link, to workout 8:\n%s", body)
+	}
+}
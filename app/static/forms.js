@@ -0,0 +1,53 @@
+// Helpers for showing application/problem+json errors inside a form, and
+// for sending the page's CSRF token with fetch().
+
+// csrfHeaders returns headers with the X-CSRF-Token the layout put in the
+// csrf-token meta tag, which every POST from a page must carry.
+function csrfHeaders(headers = {}) {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    return { ...headers, 'X-CSRF-Token': meta ? meta.content : '' };
+}
+
+function clearProblem(form) {
+    form.querySelectorAll('.field-error').forEach(el => el.remove());
+    form.querySelectorAll('.invalid').forEach(el => el.classList.remove('invalid'));
+}
+
+function fieldErrorElement(text) {
+    const el = document.createElement('div');
+    el.className = 'field-error';
+    el.textContent = text;
+    return el;
+}
+
+// showProblem renders the problem in a failed response: each entry of
+// errors is shown under the input with the same name, and anything that
+// has no visible input is shown at the top of the form.
+async function showProblem(form, response) {
+    clearProblem(form);
+
+    let problem;
+    try {
+        problem = await response.json();
+    } catch {
+        problem = { detail: `Server responded with status ${response.status}` };
+    }
+
+    const general = [];
+    for (const err of problem.errors || []) {
+        const text = `${err.field.replace(/_/g, ' ')} ${err.message}`;
+        const input = form.querySelector(`[name="${err.field}"]`);
+        if (input && input.type !== 'hidden') {
+            input.classList.add('invalid');
+            input.insertAdjacentElement('afterend', fieldErrorElement(text));
+        } else {
+            general.push(text);
+        }
+    }
+    if (!problem.errors || problem.errors.length === 0) {
+        general.push(problem.detail || problem.title || 'Something went wrong.');
+    }
+    if (general.length > 0) {
+        form.prepend(fieldErrorElement(general.join('; ')));
+    }
+}
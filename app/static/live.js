@@ -0,0 +1,50 @@
+// Live updates: pages open an event stream for their week or day and
+// patch themselves as other devices add or delete things.
+
+// liveUpdates opens the stream for scope (such as { day_id: 3 }) and
+// calls handlers[type] with the data of each event of that type. A reset
+// event means the page missed more than the server kept, so it reloads.
+function liveUpdates(scope, handlers) {
+    if (!window.EventSource) {
+        return;
+    }
+    const source = new EventSource('/events?' + new URLSearchParams(scope));
+    source.addEventListener('reset', () => location.reload());
+    for (const [type, handle] of Object.entries(handlers)) {
+        source.addEventListener(type, event => {
+            handle(JSON.parse(event.data)).catch(error => console.error(`Error applying ${type}:`, error));
+        });
+    }
+}
+
+// fetchResource loads a resource from the JSON API.
+async function fetchResource(path) {
+    const response = await fetch(path, { headers: { Accept: 'application/json' } });
+    if (!response.ok) {
+        throw new Error(`${path}: status ${response.status}`);
+    }
+    return response.json();
+}
+
+// linkButton returns <a href="href"><button>text</button></a>.
+function linkButton(href, text) {
+    const link = document.createElement('a');
+    link.href = href;
+    const button = document.createElement('button');
+    button.type = 'button';
+    button.textContent = text;
+    link.append(button);
+    return link;
+}
+
+// upsertItem adds an <li id="id"> built by build() to list, unless the
+// page already shows it.
+function upsertItem(list, id, build) {
+    if (document.getElementById(id)) {
+        return;
+    }
+    const item = document.createElement('li');
+    item.id = id;
+    build(item);
+    list.append(item);
+}
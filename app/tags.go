@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"lab8-go/service"
+)
+
+// addTagRoutes registers the JSON API for tags: creating, renaming and
+// deleting them, setting the tags of a workout, and adding up a week's
+// workouts by tag. Lists of weeks, days and workouts take a tag filter.
+func addTagRoutes() {
+	handleJSON("GET /api/v1/tags", apiListTags)
+	handleJSON("POST /api/v1/tags", apiCreateTag)
+	handleJSON("GET /api/v1/tags/{id}", apiGetTag)
+	handleJSON("PUT /api/v1/tags/{id}", apiRenameTag)
+	handleJSON("DELETE /api/v1/tags/{id}", apiDeleteTag)
+
+	handleJSON("GET /api/v1/workouts/{id}/tags", apiListWorkoutTags)
+	handleJSON("PUT /api/v1/workouts/{id}/tags", apiSetWorkoutTags)
+	handleJSON("DELETE /api/v1/workouts/{id}/tags/{tag_id}", apiUntagWorkout)
+
+	handleJSON("GET /api/v1/weeks/{id}/tag-totals", apiWeekTagTotals)
+}
+
+func apiListTags(w http.ResponseWriter, r *http.Request) {
+	p, ok := parseList(w, r, service.TagList)
+	if !ok {
+		return
+	}
+	tags, err := svc.ListTags(r.Context(), p)
+	if err != nil {
+		writeErr(w, r, "fetching tags", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func apiCreateTag(w http.ResponseWriter, r *http.Request) {
+	var req service.TagInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	tag, err := svc.CreateTag(r.Context(), req)
+	if err != nil {
+		writeErr(w, r, "creating tag", err)
+		return
+	}
+	writeCreated(w, fmt.Sprintf("/api/v1/tags/%d", tag.ID), tag)
+}
+
+func apiGetTag(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	tag, err := svc.GetTag(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, "fetching tag", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+func apiRenameTag(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req service.TagInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	tag, err := svc.RenameTag(r.Context(), id, req)
+	if err != nil {
+		writeErr(w, r, "renaming tag", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+func apiDeleteTag(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := svc.DeleteTag(r.Context(), id); err != nil {
+		writeErr(w, r, "deleting tag", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiListWorkoutTags(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	tags, err := svc.WorkoutTags(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, "fetching tags", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func apiSetWorkoutTags(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req service.WorkoutTagsInput
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	tags, err := svc.SetWorkoutTags(r.Context(), id, req)
+	if err != nil {
+		writeErr(w, r, "tagging workout", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func apiUntagWorkout(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var v service.Validator
+	tagID := v.ID("tag_id", r.PathValue("tag_id"))
+	if err := v.Err(); err != nil {
+		writeErr(w, r, "", err)
+		return
+	}
+	if err := svc.UntagWorkout(r.Context(), id, tagID); err != nil {
+		writeErr(w, r, "untagging workout", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiWeekTagTotals(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	totals, err := svc.WeekTagTotals(r.Context(), id)
+	if err != nil {
+		writeErr(w, r, "adding up tags", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, totals)
+}
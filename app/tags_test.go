@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"lab8-go/service"
+)
+
+func TestTagFilterNeedsATag(t *testing.T) {
+	parsers := map[string]func(url.Values) (service.ListParams, error){
+		"weeks":    service.WeekList.Parse,
+		"days":     service.DayList.Parse,
+		"workouts": service.WorkoutList.Parse,
+	}
+	for name, parse := range parsers {
+		if _, err := parse(url.Values{"tag": {"Push, legs"}}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		_, err := parse(url.Values{"tag": {" , "}})
+		var perr *service.ParamError
+		if !errors.As(err, &perr) || perr.Param != "tag" {
+			t.Errorf("%s: err %v, want a ParamError for tag", name, err)
+		}
+	}
+}
+
+func TestSetWorkoutTagsReportsEachBadTag(t *testing.T) {
+	body := `{"tags": ["push", "push, pull", " ", "` + strings.Repeat("x", service.MaxTagLength+1) + `"]}`
+	r := httptest.NewRequest("PUT", "/api/v1/workouts/3/tags", strings.NewReader(body))
+	r.SetPathValue("id", "3")
+	rec := httptest.NewRecorder()
+	apiSetWorkoutTags(rec, r)
+
+	if rec.Code != 400 {
+		t.Fatalf("status %d, want 400: %s", rec.Code, rec.Body)
+	}
+	for _, want := range []string{`"tags[1]"`, `"tags[2]"`, `"tags[3]"`} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("problem does not mention %s: %s", want, rec.Body)
+		}
+	}
+	if strings.Contains(rec.Body.String(), `"tags[0]"`) {
+		t.Errorf("problem rejects a valid tag: %s", rec.Body)
+	}
+}
+
+func TestWorkoutsPageShowsTags(t *testing.T) {
+	body := renderTestPage(t, "workouts", workoutsPage{
+		DayID:    3,
+		Workouts: []service.Workout{{ID: 7, Name: "Push"}, {ID: 8, Name: "Run"}},
+		Tags:     map[int][]service.Tag{7: {{ID: 1, Name: "deload"}, {ID: 2, Name: "push"}}},
+	})
+	for _, want := range []string{`href="/weeks?tag=deload">deload</a>`, `value="deload, push"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page is missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestDaysPageShowsTagTotals(t *testing.T) {
+	body := renderTestPage(t, "days", daysPage{
+		WeekID:    2,
+		Days:      []service.Day{{ID: 5, WeekID: 2, DayDate: "2024-03-04"}},
+		TagTotals: []service.TagTotal{{TagID: 1, Tag: "legs", Sessions: 2, Minutes: 95, Volume: 5250.4}},
+	})
+	for _, want := range []string{`href="/days?week_id=2&tag=legs">legs</a>`, "<td>95</td>", "<td>5250</td>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page is missing %s:\n%s", want, body)
+		}
+	}
+}
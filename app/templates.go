@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// assets holds the templates and static files, so the binary does not
+// depend on its working directory.
+//
+//go:embed templates static
+var assets embed.FS
+
+// devMode serves templates and static files from disk instead of the
+// embedded copies, re-reading them on every request so edits show up on
+// reload. It is set by the -dev flag.
+var devMode bool
+
+// pageTemplates maps a page name ("workouts" for templates/workouts.html)
+// to that page parsed together with the layout and partials.
+var pageTemplates map[string]*template.Template
+
+// assetFS returns the directory dir of the embedded assets, or of the
+// working directory in dev mode.
+func assetFS(dir string) fs.FS {
+	if devMode {
+		return os.DirFS(dir)
+	}
+	sub, err := fs.Sub(assets, dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return sub
+}
+
+// initTemplates parses every page once at startup. A template error stops
+// the server here rather than failing a request later.
+func initTemplates() {
+	pages, err := parseTemplates(assetFS("templates"))
+	if err != nil {
+		log.Fatalf("Error parsing templates: %v", err)
+	}
+	pageTemplates = pages
+}
+
+// parseTemplates parses layout.html and partials/*.html once and clones
+// them for each page, so every page can define its own "title", "head"
+// and "content" blocks.
+func parseTemplates(fsys fs.FS) (map[string]*template.Template, error) {
+	base, err := template.New("layout.html").Funcs(csrfFuncs).ParseFS(fsys, "layout.html", "partials/*.html")
+	if err != nil {
+		return nil, err
+	}
+	files, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	pages := map[string]*template.Template{}
+	for _, file := range files {
+		if file == "layout.html" {
+			continue
+		}
+		tmpl, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpl.ParseFS(fsys, file); err != nil {
+			return nil, err
+		}
+		pages[strings.TrimSuffix(path.Base(file), ".html")] = tmpl
+	}
+	return pages, nil
+}
+
+// renderPage renders a page into a buffer first, so a template error
+// becomes a 500 instead of a half-written page. Every page gets the
+// request's CSRF token through the csrfToken function.
+func renderPage(w http.ResponseWriter, r *http.Request, name string, data any) {
+	renderPageStatus(w, r, http.StatusOK, name, data)
+}
+
+// renderPageStatus is renderPage with a status other than 200.
+func renderPageStatus(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	var buf bytes.Buffer
+	tmpl, err := lookupPage(name)
+	if err == nil {
+		// Execute a clone: the parsed page must stay unexecuted to be
+		// cloned again, and the clone binds this request's token.
+		tmpl, err = tmpl.Clone()
+	}
+	if err == nil {
+		token := csrfToken(w, r)
+		err = tmpl.Funcs(template.FuncMap{"csrfToken": func() string { return token }}).ExecuteTemplate(&buf, "layout", data)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering template", "template", name, "err", err)
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// lookupPage returns the parsed page. In dev mode the templates are parsed
+// again from disk, and a syntax error is reported for the request instead
+// of stopping the server.
+func lookupPage(name string) (*template.Template, error) {
+	pages := pageTemplates
+	if devMode {
+		var err error
+		if pages, err = parseTemplates(assetFS("templates")); err != nil {
+			return nil, err
+		}
+	}
+	tmpl, ok := pages[name]
+	if !ok {
+		return nil, fmt.Errorf("no template %q", name)
+	}
+	return tmpl, nil
+}
+
+// staticHandler serves the static assets under /static/.
+func staticHandler() http.Handler {
+	return http.StripPrefix("/static/", http.FileServer(http.FS(assetFS("static"))))
+}
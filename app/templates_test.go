@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedTemplatesParse(t *testing.T) {
+	cfg = defaultConfig()
+	initTemplates()
+	for _, name := range []string{"index", "weeks", "days", "workouts", "meals", "lifts", "analytics", "docs", "error", "session", "search"} {
+		if pageTemplates[name] == nil {
+			t.Errorf("page %q was not parsed", name)
+		}
+	}
+
+	if body := renderTestPage(t, "index", nil); !strings.Contains(body, "<title>Workout Tracker</title>") || !strings.Contains(body, "/static/styles.css") {
+		t.Errorf("index is not wrapped in the layout:\n%s", body)
+	}
+}
+
+// renderTestPage renders the named page with data from the embedded
+// templates and returns the HTML, failing the test unless it renders.
+func renderTestPage(t *testing.T, name string, data any) string {
+	t.Helper()
+	cfg = defaultConfig()
+	initTemplates()
+	rec := httptest.NewRecorder()
+	renderPage(rec, httptest.NewRequest("GET", "/", nil), name, data)
+	if rec.Code != 200 {
+		t.Fatalf("rendering %s: status %d: %s", name, rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// visits aggregates requests per route and hour in memory. Handlers never
+// touch the database for analytics; the aggregates are written in one
+// transaction every analytics.flush_interval and once more at shutdown.
+var visits = &visitCounter{hours: map[routeHour]*hourStats{}}
+
+// latencyBucketsMS are the upper bounds, in milliseconds, of the latency
+// histogram kept for each route and hour; a last bucket holds everything
+// slower. p50 and p95 are estimated from it.
+var latencyBucketsMS = [...]float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+type routeHour struct {
+	route string
+	hour  time.Time // UTC, truncated to the hour
+}
+
+type hourStats struct {
+	requests int64
+	errors   int64                            // responses with a 5xx status
+	latency  [len(latencyBucketsMS) + 1]int64 // counts per latencyBucketsMS, then slower
+	clients  map[string]bool                  // clientKey of each caller
+}
+
+func (s *hourStats) merge(o *hourStats) {
+	s.requests += o.requests
+	s.errors += o.errors
+	for i, n := range o.latency {
+		s.latency[i] += n
+	}
+	for c := range o.clients {
+		s.clients[c] = true
+	}
+}
+
+type visitCounter struct {
+	mu    sync.Mutex
+	hours map[routeHour]*hourStats
+}
+
+// record adds one request to its route's current hour.
+func (c *visitCounter) record(route string, at time.Time, status int, elapsed time.Duration, client string) {
+	ms := float64(elapsed) / float64(time.Millisecond)
+	bucket := sort.SearchFloat64s(latencyBucketsMS[:], ms)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := routeHour{route, at.UTC().Truncate(time.Hour)}
+	stats := c.hours[key]
+	if stats == nil {
+		stats = &hourStats{clients: map[string]bool{}}
+		c.hours[key] = stats
+	}
+	stats.requests++
+	if status >= 500 {
+		stats.errors++
+	}
+	stats.latency[bucket]++
+	stats.clients[client] = true
+}
+
+// take returns the aggregates so far and starts over.
+func (c *visitCounter) take() map[routeHour]*hourStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hours := c.hours
+	c.hours = map[routeHour]*hourStats{}
+	return hours
+}
+
+// restore puts back aggregates that could not be written.
+func (c *visitCounter) restore(hours map[routeHour]*hourStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, stats := range hours {
+		if cur := c.hours[key]; cur != nil {
+			cur.merge(stats)
+		} else {
+			c.hours[key] = stats
+		}
+	}
+}
+
+// flush adds the aggregates so far to the hourly tables and the all-time
+// endpoint_visits totals. If the write fails they are kept and retried
+// with the next flush.
+func (c *visitCounter) flush(ctx context.Context) error {
+	hours := c.take()
+	if len(hours) == 0 {
+		return nil
+	}
+	if err := writeVisits(ctx, hours); err != nil {
+		c.restore(hours)
+		return err
+	}
+	slog.Debug("Flushed visit statistics", "route_hours", len(hours))
+	return nil
+}
+
+func writeVisits(ctx context.Context, hours map[routeHour]*hourStats) error {
+	// Rows are written in a fixed order so two instances flushing at once
+	// cannot deadlock on each other.
+	keys := make([]routeHour, 0, len(hours))
+	for key := range hours {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].hour.Before(keys[j].hour)
+	})
+
+	var (
+		totals                                = map[string]int64{}
+		routes, hourValues                    []string
+		requests, errs                        []int64
+		latRoutes, latHours, latBounds        []string
+		latCounts                             []int64
+		clientRoutes, clientHours, clientKeys []string
+	)
+	for _, key := range keys {
+		stats := hours[key]
+		hour := key.hour.Format(time.RFC3339)
+		totals[key.route] += stats.requests
+		routes = append(routes, key.route)
+		hourValues = append(hourValues, hour)
+		requests = append(requests, stats.requests)
+		errs = append(errs, stats.errors)
+		for i, n := range stats.latency {
+			if n == 0 {
+				continue
+			}
+			bound := "Infinity"
+			if i < len(latencyBucketsMS) {
+				bound = strconv.FormatFloat(latencyBucketsMS[i], 'g', -1, 64)
+			}
+			latRoutes = append(latRoutes, key.route)
+			latHours = append(latHours, hour)
+			latBounds = append(latBounds, bound)
+			latCounts = append(latCounts, n)
+		}
+		for client := range stats.clients {
+			clientRoutes = append(clientRoutes, key.route)
+			clientHours = append(clientHours, hour)
+			clientKeys = append(clientKeys, client)
+		}
+	}
+	totalRoutes := make([]string, 0, len(totals))
+	for route := range totals {
+		totalRoutes = append(totalRoutes, route)
+	}
+	sort.Strings(totalRoutes)
+	totalCounts := make([]int64, len(totalRoutes))
+	for i, route := range totalRoutes {
+		totalCounts[i] = totals[route]
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`
+        INSERT INTO endpoint_visits (endpoint, visit_count)
+        SELECT * FROM unnest($1::text[], $2::bigint[])
+        ON CONFLICT (endpoint) DO UPDATE
+        SET visit_count = endpoint_visits.visit_count + EXCLUDED.visit_count`,
+			[]any{pq.Array(totalRoutes), pq.Array(totalCounts)}},
+		{`
+        INSERT INTO route_stats_hourly (route, hour, requests, errors)
+        SELECT * FROM unnest($1::text[], $2::timestamptz[], $3::bigint[], $4::bigint[])
+        ON CONFLICT (route, hour) DO UPDATE
+        SET requests = route_stats_hourly.requests + EXCLUDED.requests,
+            errors = route_stats_hourly.errors + EXCLUDED.errors`,
+			[]any{pq.Array(routes), pq.Array(hourValues), pq.Array(requests), pq.Array(errs)}},
+		{`
+        INSERT INTO route_latency_hourly (route, hour, le_ms, count)
+        SELECT * FROM unnest($1::text[], $2::timestamptz[], $3::float8[], $4::bigint[])
+        ON CONFLICT (route, hour, le_ms) DO UPDATE
+        SET count = route_latency_hourly.count + EXCLUDED.count`,
+			[]any{pq.Array(latRoutes), pq.Array(latHours), pq.Array(latBounds), pq.Array(latCounts)}},
+		{`
+        INSERT INTO route_clients_hourly (route, hour, client)
+        SELECT * FROM unnest($1::text[], $2::timestamptz[], $3::text[])
+        ON CONFLICT DO NOTHING`,
+			[]any{pq.Array(clientRoutes), pq.Array(clientHours), pq.Array(clientKeys)}},
+	}
+	for _, st := range statements {
+		if _, err := tx.ExecContext(ctx, st.query, st.args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// pruneVisits deletes hourly statistics older than analytics.retention.
+// The all-time totals in endpoint_visits are kept.
+func pruneVisits(ctx context.Context) error {
+	cutoff := time.Now().Add(-cfg.Analytics.Retention)
+	for _, table := range []string{"route_stats_hourly", "route_latency_hourly", "route_clients_hourly"} {
+		if _, err := db.ExecContext(ctx, "DELETE FROM "+table+" WHERE hour < $1", cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startVisitFlusher flushes the visit statistics on an interval until the
+// server shuts down, and a last time during shutdown. It prunes old
+// statistics at startup and then hourly.
+func startVisitFlusher() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(cfg.Analytics.FlushInterval)
+		defer ticker.Stop()
+		var pruned time.Time
+		for {
+			if time.Since(pruned) >= time.Hour {
+				if err := pruneVisits(context.Background()); err != nil {
+					slog.Error("Error pruning visit statistics", "err", err)
+				}
+				pruned = time.Now()
+			}
+			select {
+			case <-ticker.C:
+				if err := visits.flush(context.Background()); err != nil {
+					slog.Error("Error flushing visit statistics", "err", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	onShutdown(func(ctx context.Context) {
+		close(stop)
+		<-done
+		if err := visits.flush(ctx); err != nil {
+			slog.Error("Error flushing visit statistics at shutdown", "err", err)
+		}
+	})
+}
+
+// countVisits records every request that mux routes somewhere, keyed by
+// the route's path pattern, with its status, latency and caller.
+func countVisits(mux *http.ServeMux) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routeLabel(mux, r)
+			if route == "unmatched" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			visits.record(route, start, sw.status, time.Since(start), clientKey(r))
+		})
+	}
+}
+
+// clientKey identifies the caller for unique-client counts without storing
+// its address: a short hash of the remote IP.
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	sum := sha256.Sum256([]byte(host))
+	return hex.EncodeToString(sum[:8])
+}
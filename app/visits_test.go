@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCountVisitsByRouteAndHour(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /list-lifts", func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc("GET /api/v1/days/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "2" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	handler := countVisits(mux)(mux)
+	visits.take()
+
+	for _, call := range []struct{ path, addr string }{
+		{"/list-lifts", "10.0.0.1:1234"},
+		{"/list-lifts", "10.0.0.1:5678"},
+		{"/api/v1/days/1", "10.0.0.1:1234"},
+		{"/api/v1/days/2", "10.0.0.2:1234"},
+		{"/nowhere", "10.0.0.1:1234"},
+	} {
+		r := httptest.NewRequest("GET", call.path, nil)
+		r.RemoteAddr = call.addr
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	hour := time.Now().UTC().Truncate(time.Hour)
+	got := visits.take()
+	if len(got) != 2 {
+		t.Fatalf("got %d route hours, want 2: %v", len(got), got)
+	}
+	for route, want := range map[string]struct{ requests, errors, clients int }{
+		"/list-lifts":       {2, 0, 1},
+		"/api/v1/days/{id}": {2, 1, 2},
+	} {
+		stats := got[routeHour{route, hour}]
+		if stats == nil {
+			t.Errorf("%s was not counted", route)
+			continue
+		}
+		if int(stats.requests) != want.requests || int(stats.errors) != want.errors || len(stats.clients) != want.clients {
+			t.Errorf("%s: requests %d, errors %d, clients %d; want %+v", route, stats.requests, stats.errors, len(stats.clients), want)
+		}
+		if stats.latency[0] != stats.requests {
+			t.Errorf("%s: latency buckets %v, want every request under %vms", route, stats.latency, latencyBucketsMS[0])
+		}
+	}
+}